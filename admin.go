@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// liveConfig is the config tasks start with. Admin tasks and reloads replace
+// it as a whole, so a task keeps a consistent config until it finishes.
+var liveConfig atomic.Pointer[config]
+
+// currentConfig returns the config new tasks get, which is the config of
+// this session if the daemon isn't listening, as in tests.
+func (s *session) currentConfig() *config {
+	if cfg := liveConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return s.cfg
+}
+
+type configResult struct {
+	MaxSpeculations      int      `json:"max_speculations"`
+	Root                 string   `json:"root"`
+	Verbose              bool     `json:"verbose"`
+	LenientJSON          bool     `json:"lenient_json"`
+	SharedSpeculation    bool     `json:"shared_speculation"`
+	AllowPrefixes        []string `json:"allow_prefixes"`
+	IdleTimeout          string   `json:"idle_timeout"`
+	MaxWriteBytes        int64    `json:"max_write_bytes"`
+	CopyBufferSize       int      `json:"copy_buffer_size"`
+	SpeculateConcurrency int      `json:"speculate_concurrency"`
+	CleanConcurrency     int      `json:"clean_concurrency"`
+	Debug                bool     `json:"debug"`
+	LogSample            int      `json:"log_sample"`
+	Quiet                bool     `json:"quiet"`
+	MaxLogContent        int      `json:"max_log_content"`
+	NoSpeculation        bool     `json:"no_speculation"`
+	MakeParents          bool     `json:"make_parents"`
+	AdminEnabled         bool     `json:"admin_enabled"`
+	LogLevel             string   `json:"log_level"`
+	MaxContentMemory     int64    `json:"max_content_memory"`
+	MaxInFlight          int64    `json:"max_in_flight"`
+	DirCacheSize         int      `json:"dir_cache_size"`
+}
+
+// admin runs an operator command. It's available only with --admin-enabled.
+func (s *session) admin(command string, value *string) (string, error) {
+	if !s.cfg.adminEnabled {
+		return valInvalid, fmt.Errorf("%w: admin requires --admin-enabled", os.ErrPermission)
+	}
+
+	switch command {
+	case "get_config":
+		return s.getConfig()
+	case "set_root":
+		if value == nil {
+			return s.needMoreParameters()
+		}
+		return s.setRoot(*value)
+	default:
+		return valInvalid, fmt.Errorf("unknown admin command: %s", command)
+	}
+}
+
+func (s *session) getConfig() (string, error) {
+	cfg := s.currentConfig()
+
+	j, err := json.Marshal(&configResult{
+		MaxSpeculations:      cfg.maxSpeculations,
+		Root:                 cfg.root,
+		Verbose:              cfg.verbose,
+		LenientJSON:          cfg.lenientJSON,
+		SharedSpeculation:    cfg.sharedSpeculation,
+		AllowPrefixes:        cfg.allowPrefixes,
+		IdleTimeout:          cfg.idleTimeout.String(),
+		MaxWriteBytes:        cfg.maxWriteBytes,
+		CopyBufferSize:       cfg.copyBufferSize,
+		SpeculateConcurrency: cfg.speculateConcurrency,
+		CleanConcurrency:     cfg.cleanConcurrency,
+		Debug:                cfg.debug,
+		LogSample:            cfg.logSample,
+		Quiet:                cfg.quiet,
+		MaxLogContent:        cfg.maxLogContent,
+		NoSpeculation:        cfg.noSpeculation,
+		MakeParents:          cfg.makeParents,
+		AdminEnabled:         cfg.adminEnabled,
+		LogLevel:             cfg.logLevel.String(),
+		MaxContentMemory:     cfg.maxContentMemory,
+		MaxInFlight:          cfg.maxInFlight,
+		DirCacheSize:         cfg.dirCacheSize,
+	})
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
+
+// setRoot changes the root of the tasks started from now on in every
+// session. Tasks already running keep resolving against the old root.
+func (s *session) setRoot(root string) (string, error) {
+	if !filepath.IsAbs(root) {
+		return valFalse, fmt.Errorf("root must be absolute: %s", root)
+	}
+	root = filepath.Clean(root)
+
+	if err := s.checkAllowed(root); err != nil {
+		return valFalse, err
+	}
+
+	st, err := os.Stat(root)
+	if err != nil {
+		return valFalse, err
+	}
+	if !st.IsDir() {
+		return valFalse, fmt.Errorf("root is not a directory: %s", root)
+	}
+
+	for {
+		old := liveConfig.Load()
+		if old == nil {
+			return valFalse, fmt.Errorf("not listening")
+		}
+
+		cfg := *old
+		cfg.root = root
+
+		// Retry rather than overwrite a change made concurrently.
+		if liveConfig.CompareAndSwap(old, &cfg) {
+			return valTrue, nil
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Admin(t *testing.T) {
+	listening := func(p *testpack) {
+		p.sess.cfg.adminEnabled = true
+		liveConfig.Store(p.sess.cfg)
+	}
+
+	t.Run("disabled", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"admin": "get_config"}`))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("get_config", run(func(p *testpack) {
+		p.sess.cfg.maxContentMemory = 1 << 20
+		p.sess.cfg.maxInFlight = 8
+		p.sess.cfg.dirCacheSize = 16
+		listening(p)
+		defer liveConfig.Store(nil)
+
+		res, err := p.sess.addTask([]byte(`{"admin": "get_config"}`))
+		p.assert.NoError(err)
+
+		var cfg configResult
+		p.assert.NoError(json.Unmarshal([]byte(res), &cfg))
+		p.assert.True(cfg.AdminEnabled)
+		p.assert.Equal(p.sess.cfg.copyBufferSize, cfg.CopyBufferSize)
+		p.assert.Equal(p.sess.cfg.maxContentMemory, cfg.MaxContentMemory)
+		p.assert.Equal(p.sess.cfg.maxInFlight, cfg.MaxInFlight)
+		p.assert.Equal(p.sess.cfg.dirCacheSize, cfg.DirCacheSize)
+	}))
+
+	t.Run("set_root", run(func(p *testpack) {
+		listening(p)
+		defer liveConfig.Store(nil)
+		p.fs.dir(testDir1).create()
+		root := filepath.Clean(p.fs.path(testDir1))
+
+		res, err := p.sess.addTask(taskf(`{"admin": "set_root", "value": "%s"}`, root))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		// The task changing the root kept the old one.
+		p.assert.Equal(root, liveConfig.Load().root)
+		p.assert.Equal("", p.sess.cfg.root)
+
+		// Later tasks of running sessions use the new root, as well as new sessions.
+		res, err = p.sess.addTask(taskf(`{"dest": "%s", "content_b64": "%s"}`, testFile1, b64String(testContent1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+
+		sess := newSession(defaultConfig())
+		defer sess.finalize()
+
+		res, err = sess.addTask(taskf(`{"dest": "%s", "content_b64": "%s"}`, testFile2, b64String(testContent2)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testDir1File2).read())
+
+		res, err = p.sess.addTask([]byte(`{"admin": "get_config"}`))
+		p.assert.NoError(err)
+
+		var cfg configResult
+		p.assert.NoError(json.Unmarshal([]byte(res), &cfg))
+		p.assert.Equal(root, cfg.Root)
+	}))
+
+	t.Run("set_root relative", run(func(p *testpack) {
+		listening(p)
+		defer liveConfig.Store(nil)
+
+		res, err := p.sess.addTask([]byte(`{"admin": "set_root", "value": "relative"}`))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("set_root inexistent", run(func(p *testpack) {
+		listening(p)
+		defer liveConfig.Store(nil)
+
+		res, err := p.sess.addTask(taskf(`{"admin": "set_root", "value": "%s"}`, p.fs.path(testDir1)))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal("", liveConfig.Load().root)
+	}))
+
+	t.Run("set_root without value", run(func(p *testpack) {
+		listening(p)
+		defer liveConfig.Store(nil)
+
+		res, err := p.sess.addTask([]byte(`{"admin": "set_root"}`))
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("unknown command", run(func(p *testpack) {
+		listening(p)
+		defer liveConfig.Store(nil)
+
+		res, err := p.sess.addTask([]byte(`{"admin": "reboot"}`))
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+}
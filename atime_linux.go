@@ -0,0 +1,19 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileAtime returns the access time of the file.
+func fileAtime(fi os.FileInfo) time.Time {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(st.Atim.Unix())
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// fileAtime returns the zero time, which os.Chtimes leaves unchanged.
+func fileAtime(fi os.FileInfo) time.Time {
+	return time.Time{}
+}
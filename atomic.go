@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxTempAttempts = 10000
+
+// tempSiblingName returns a random hidden name next to destPath.
+func tempSiblingName(destPath string) string {
+	dir, base := filepath.Split(destPath)
+	return dir + "." + base + "." + strconv.FormatUint(uint64(rand.Uint32()), 36) + ".tmp"
+}
+
+// createTempSibling creates a hidden file next to destPath. Unlike
+// os.CreateTemp, the mode is subject to umask just like an ordinary creation.
+func createTempSibling(destPath string, perm os.FileMode) (*os.File, error) {
+	for i := 0; i < maxTempAttempts; i++ {
+		name := tempSiblingName(destPath)
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		return file, err
+	}
+
+	return nil, fmt.Errorf("failed to create a temporary file for: %s", destPath)
+}
+
+// createFileAtomically writes content to a temporary file, applies the mode
+// and the owner, and then renames it to dest, so that the file never appears
+// with partial content or wrong attributes.
+func (s *session) createFileAtomically(content []byte, destPath string, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("createFileAtomically took %s", time.Since(start))
+	}()
+
+	// A speculatively created file is replaced even without overwrite since it
+	// doesn't exist logically.
+	replace := opts.overwrite
+	if exists, found := s.speculativeExistence(destPath); found {
+		if exists && !opts.overwrite {
+			return valExists, nil
+		}
+		replace = replace || !exists
+	}
+
+	if _, err := s.takeOverDest(destPath); err != nil {
+		return valFalse, err
+	}
+
+	// Keep the mode of the file being replaced unless specified.
+	if opts.perm == nil {
+		if st, err := os.Stat(destPath); err == nil {
+			perm := st.Mode().Perm()
+			o := *opts
+			o.perm = &perm
+			opts = &o
+		}
+	}
+
+	if opts.makeParents {
+		if err := s.makeParents(destPath, opts.dirPerm); err != nil {
+			return valFalse, err
+		}
+	}
+
+	tmp, err := createTempSibling(destPath, 0666)
+	if err != nil {
+		return valFalse, err
+	}
+	tmpPath := tmp.Name()
+
+	committed := false
+	defer func() {
+		if !committed {
+			if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+				log.Warn(err)
+			}
+		}
+	}()
+
+	if err := s.writeTemp(tmp, content, opts); err != nil {
+		tmp.Close()
+		return valFalse, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return valFalse, err
+	}
+
+	if replace {
+		if err := os.Rename(tmpPath, destPath); err != nil {
+			return valFalse, err
+		}
+		committed = true
+		return valTrue, nil
+	}
+
+	// Unlike rename, link never replaces an existing file.
+	if err := os.Link(tmpPath, destPath); err != nil {
+		if errors.Is(err, syscall.EEXIST) {
+			return valExists, nil
+		}
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
+
+// writeTemp is the ordered pipeline of createFileAtomically before the rename.
+func (s *session) writeTemp(tmp *os.File, content []byte, opts *writeOptions) error {
+	if _, err := writeFile(tmp, content); err != nil {
+		return err
+	}
+
+	if opts.perm != nil {
+		if err := tmp.Chmod(*opts.perm); err != nil {
+			return err
+		}
+	}
+
+	// Before the rename, so the file never appears without the xattr.
+	if opts.storeSize {
+		if err := setSizeXattr(tmp, int64(len(content))); err != nil {
+			return err
+		}
+	}
+
+	if err := chownFile(tmp, opts.uid, opts.gid); err != nil {
+		return err
+	}
+
+	// Before the rename, so the file never appears with unsynced content.
+	if opts.fsync {
+		return syncFile(tmp)
+	}
+
+	return nil
+}
+
+// chownFile changes the owner of the file. A nil ID is left unchanged.
+func chownFile(file *os.File, uid, gid *int) error {
+	if uid == nil && gid == nil {
+		return nil
+	}
+
+	// -1 leaves the ID unchanged.
+	u, g := -1, -1
+	if uid != nil {
+		u = *uid
+	}
+	if gid != nil {
+		g = *gid
+	}
+
+	return file.Chown(u, g)
+}
@@ -0,0 +1,89 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func ownerOf(p *testpack, path string) (int, int) {
+	st, err := os.Stat(path)
+	p.assert.NoError(err)
+
+	sys := st.Sys().(*syscall.Stat_t)
+	return int(sys.Uid), int(sys.Gid)
+}
+
+func Test_CreateFile_Atomic(t *testing.T) {
+	t.Run("mode and owner", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "perm": %d, "uid": %d, "gid": %d, "atomic": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1),
+			testFilePerm1,
+			os.Getuid(),
+			os.Getgid()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+
+		uid, gid := ownerOf(p, p.fs.path(testFile1))
+		p.assert.Equal(os.Getuid(), uid)
+		p.assert.Equal(os.Getgid(), gid)
+
+		entries, _ := os.ReadDir(p.fs.path(testRootDir))
+		p.assert.Len(entries, 1)
+	}))
+
+	t.Run("replace keeps mode", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		os.Chmod(p.fs.path(testFile1), testFilePerm1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "atomic": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+	}))
+
+	t.Run("no overwrite", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "atomic": true, "overwrite": false}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+
+		entries, _ := os.ReadDir(p.fs.path(testRootDir))
+		p.assert.Len(entries, 1)
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "atomic": true, "overwrite": false}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
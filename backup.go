@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backupSuffix names the backup of "dest" kept by "backup".
+const backupSuffix = "~"
+
+// withBackup keeps the existing file at destPath as destPath~ and then runs
+// place. It returns the backup path in JSON on success, or the result of
+// place as is if there was nothing to back up.
+func (s *session) withBackup(destPath string, place func() (string, error)) (string, error) {
+	backupPath, err := s.backup(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	res, err := place()
+	if err != nil || res != valTrue || backupPath == "" {
+		return res, err
+	}
+
+	j, err := json.Marshal(backupPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
+
+// backup hard links the file at destPath to destPath~, replacing an older
+// backup, so that destPath never goes missing. It falls back to copying
+// where hard links aren't supported. It returns the backup path, or "" if
+// destPath doesn't exist.
+func (s *session) backup(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("backup took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return "", nil
+	}
+
+	fi, err := os.Lstat(destPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("cannot back up a non-regular file: %s", destPath)
+	}
+
+	backupPath := destPath + backupSuffix
+
+	if _, err := s.takeOverDest(backupPath); err != nil {
+		return "", err
+	}
+
+	// Link under a temporary name first so that an older backup is replaced
+	// atomically too.
+	tmp := tempSiblingName(backupPath)
+	if err := os.Link(destPath, tmp); err != nil {
+		log.Debugf("falling back to copy for backup: %s: %s", destPath, err)
+
+		perm := fi.Mode().Perm()
+		res, err := s.copyFile(destPath, tmp, &writeOptions{perm: &perm})
+		if err != nil {
+			os.Remove(tmp)
+			return "", err
+		}
+		if res != valTrue {
+			return "", fmt.Errorf("failed to copy for backup: %s", destPath)
+		}
+	}
+
+	if err := os.Rename(tmp, backupPath); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return backupPath, nil
+}
+
+// copyByRename copies src to a temporary file and renames it to dest, so
+// that dest gets a new inode instead of being overwritten in place, which
+// would change a backup hard linked to it as well.
+func (s *session) copyByRename(srcPath, destPath string, opts *writeOptions) (string, error) {
+	// The rename below always replaces dest, so refuse here like copyFile.
+	if !opts.overwrite && s.existence(destPath) {
+		return valExists, nil
+	}
+
+	if _, err := s.takeOverDest(destPath); err != nil {
+		return valFalse, err
+	}
+
+	tmpOpts := *opts
+	tmpOpts.overwrite = false
+
+	// Keep the mode of the file being replaced unless specified.
+	if tmpOpts.perm == nil {
+		if st, err := os.Stat(destPath); err == nil {
+			perm := st.Mode().Perm()
+			tmpOpts.perm = &perm
+		}
+	}
+
+	tmp := tempSiblingName(destPath)
+	res, err := s.copyFile(srcPath, tmp, &tmpOpts)
+	if err == nil && res != valTrue {
+		err = fmt.Errorf("failed to copy to a temporary file: %s", tmp)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return valFalse, err
+	}
+
+	if err := os.Rename(tmp, destPath); err != nil {
+		os.Remove(tmp)
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
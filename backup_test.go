@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func Test_Backup(t *testing.T) {
+	backupPath := func(p *testpack, res string) string {
+		var path string
+		p.assert.NoError(json.Unmarshal([]byte(res), &path))
+		return path
+	}
+
+	t.Run("move", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "backup": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(p.fs.path(testFile1)+"~", backupPath(p, res))
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+		p.assert.Equal(testContent1, p.fs.file(testFile1+"~").read())
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+
+	t.Run("copy", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "backup": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(p.fs.path(testFile1)+"~", backupPath(p, res))
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+		p.assert.Equal(testContent1, p.fs.file(testFile1+"~").read())
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("copy without overwrite", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "backup": true, "overwrite": false}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("copy keeps mode", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1).chmod(testFilePerm1)
+		p.fs.file(testFile2).write(testContent2)
+
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "backup": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+	}))
+
+	t.Run("older backup replaced", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile1 + "~").write(testContent2)
+		p.fs.file(testFile2).write(testContent2)
+
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "backup": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testContent1, p.fs.file(testFile1+"~").read())
+	}))
+
+	t.Run("nothing to back up", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "backup": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.file(testFile1 + "~").exists())
+	}))
+
+	t.Run("speculative new dest", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent2)
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "backup": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.file(testFile1 + "~").exists())
+
+		p.sess.finalize()
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "backup": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(testFile2).exists())
+	}))
+}
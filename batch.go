@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type batchEntry struct {
+	Result string  `json:"result"`
+	Error  *string `json:"error"`
+}
+
+type batchResult struct {
+	Results   []*batchEntry `json:"results"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+}
+
+var errBusy = errors.New("too many operations in flight")
+
+func isBatch(input []byte) bool {
+	trimmed := bytes.TrimLeft(input, " \t\r")
+	return len(trimmed) != 0 && trimmed[0] == '['
+}
+
+// addBatch runs the tasks in order and reports the result of each one,
+// so that clients can retry only the failed ones.
+func (s *session) addBatch(input []byte) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("addBatch took %s", time.Since(start))
+	}()
+
+	var tasks []json.RawMessage
+	if err := json.Unmarshal(input, &tasks); err != nil {
+		return valInvalid, err
+	}
+
+	// Lines streamed by a task would break the single-line batch response.
+	emit := s.emit
+	s.emit = nil
+	defer func() {
+		s.emit = emit
+	}()
+
+	res := &batchResult{
+		Results: make([]*batchEntry, 0, len(tasks)),
+	}
+	// The batch as a whole was admitted, so the tasks are never busy.
+	for _, t := range tasks {
+		r, err := s.handleTask(t)
+		if err == nil && r == valBusy {
+			err = errBusy
+		}
+
+		entry := &batchEntry{Result: r}
+		if err != nil {
+			log.Error(err)
+			msg := err.Error()
+			entry.Error = &msg
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+		res.Results = append(res.Results, entry)
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_Batch(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`[{"dest": "%s", "content_b64": "%s"}, {"dest": "%s", "existence": true}]`,
+			p.fs.path(testFile1),
+			b64String(testContent1),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(
+			`{"results":[{"result":"true","error":null},{"result":"true","error":null}],"succeeded":2,"failed":0}`,
+			res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("partial failure", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`[{"dest": "%s", "content_b64": "%s"}, {"dest": "%s", "content_b64": "%s"}]`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1),
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+
+		batch := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), batch))
+		p.assert.Equal(1, batch.Succeeded)
+		p.assert.Equal(1, batch.Failed)
+		p.assert.Equal(testResFalse, batch.Results[0].Result)
+		p.assert.NotNil(batch.Results[0].Error)
+		p.assert.Equal(testResTrue, batch.Results[1].Result)
+		p.assert.Nil(batch.Results[1].Error)
+	}))
+
+	t.Run("admitted once", run(func(p *testpack) {
+		p.sess.cfg.maxInFlight = 1
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`[{"dest": "%s", "existence": true}, {"dest": "%s", "existence": true}]`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(
+			`{"results":[{"result":"true","error":null},{"result":"false","error":null}],"succeeded":2,"failed":0}`,
+			res)
+		p.assert.Equal(int64(0), metrics.inFlight.Load())
+	}))
+
+	t.Run("busy as a whole", run(func(p *testpack) {
+		p.sess.cfg.maxInFlight = 1
+
+		metrics.inFlight.Add(1)
+		res, err := p.sess.addTask(taskf(
+			`[{"dest": "%s", "content_b64": "%s"}]`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		metrics.inFlight.Add(-1)
+
+		p.assert.NoError(err)
+		p.assert.Equal("busy", res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("invalid", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`[{"dest": "a"`))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+}
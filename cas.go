@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// casLocks serialize cas tasks on the same path daemon-wide. Paths share
+// locks by hash so that their number stays fixed. Writers other than cas
+// aren't serialized.
+var casLocks [64]sync.Mutex
+
+func casLock(path string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return &casLocks[h.Sum32()%uint32(len(casLocks))]
+}
+
+// compareAndSwap writes content only if the file currently has the expected
+// content, and returns "mismatch" otherwise. A missing file has empty
+// content. The write is atomic so that readers see either content.
+func (s *session) compareAndSwap(destPath string, expected, content []byte, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("compareAndSwap took %s", time.Since(start))
+	}()
+
+	mu := casLock(destPath)
+	mu.Lock()
+	defer mu.Unlock()
+
+	current, err := s.readForCompare(destPath, len(expected))
+	if err != nil {
+		return valFalse, err
+	}
+
+	if !bytes.Equal(current, expected) {
+		return valMismatch, nil
+	}
+
+	atomicOpts := *opts
+	atomicOpts.atomic = true
+	return s.createFile(content, destPath, &atomicOpts)
+}
+
+// readForCompare reads up to one byte more than n, which is enough to tell
+// whether the content is of n bytes. A missing file reads as empty.
+func (s *session) readForCompare(destPath string, n int) ([]byte, error) {
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return []byte{}, nil
+	}
+
+	f, err := os.Open(destPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []byte{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(io.LimitReader(f, int64(n)+1))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func Test_CAS(t *testing.T) {
+	cas := func(p *testpack, sess *session, expected, content string) (string, error) {
+		return sess.addTask(taskf(
+			`{"dest": "%s", "cas": true, "expected_b64": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(expected),
+			b64String(content)))
+	}
+
+	t.Run("match", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := cas(p, p.sess, testContent1, testContent2)
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("mismatch", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := cas(p, p.sess, testContent2, testContent2)
+
+		p.assert.NoError(err)
+		p.assert.Equal("mismatch", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("longer content", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1 + testContent2)
+
+		res, err := cas(p, p.sess, testContent1, testContent2)
+
+		p.assert.NoError(err)
+		p.assert.Equal("mismatch", res)
+	}))
+
+	t.Run("missing matches empty", run(func(p *testpack) {
+		res, err := cas(p, p.sess, "", testContent1)
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("missing mismatches", run(func(p *testpack) {
+		res, err := cas(p, p.sess, testContent1, testContent2)
+
+		p.assert.NoError(err)
+		p.assert.Equal("mismatch", res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("no expected", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "cas": true, "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("one winner", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		const n = 8
+		results := make([]string, n)
+		wg := &sync.WaitGroup{}
+		for i := 0; i < n; i++ {
+			i := i
+			sess := newSession(p.sess.cfg)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], _ = cas(p, sess, testContent1, testContent2)
+				sess.finalize()
+			}()
+		}
+		wg.Wait()
+
+		swapped := 0
+		for _, r := range results {
+			if r == testResTrue {
+				swapped++
+			}
+		}
+		p.assert.Equal(1, swapped)
+	}))
+}
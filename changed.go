@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const valChanged = "changed"
+
+// filesDiffer reports whether the destination content differs from the
+// source. This costs an extra read of both files unless their sizes differ.
+func (s *session) filesDiffer(srcPath, destPath string) (bool, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("filesDiffer took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return true, nil
+	}
+
+	dest, err := os.Open(destPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer dest.Close()
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	srcStat, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	destStat, err := dest.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !destStat.Mode().IsRegular() || srcStat.Size() != destStat.Size() {
+		return true, nil
+	}
+
+	return s.readersDiffer(src, dest)
+}
+
+// readersDiffer reports whether the two streams have different content,
+// reading both in chunks of the copy buffer size.
+func (s *session) readersDiffer(a, b io.Reader) (bool, error) {
+	aBuf := make([]byte, s.cfg.copyBufferSize)
+	bBuf := make([]byte, s.cfg.copyBufferSize)
+	for {
+		n, err := io.ReadFull(a, aBuf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+
+		m, err := io.ReadFull(b, bBuf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+
+		if !bytes.Equal(aBuf[:n], bBuf[:m]) {
+			return true, nil
+		}
+
+		if n == 0 {
+			return false, nil
+		}
+	}
+}
+
+// reportChanged replaces a successful write result with whether the content
+// changed.
+func reportChanged(res string, err error, changed bool) (string, error) {
+	if err != nil || res != valTrue {
+		return res, err
+	}
+
+	if changed {
+		return valChanged, nil
+	}
+	return valUnchanged, nil
+}
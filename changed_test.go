@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_ReportChanged(t *testing.T) {
+	t.Run("create changed", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "report_changed": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("changed", res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("create unchanged", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "report_changed": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("unchanged", res)
+	}))
+
+	t.Run("create new", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "report_changed": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("changed", res)
+	}))
+
+	t.Run("copy unchanged", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "report_changed": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("unchanged", res)
+	}))
+
+	t.Run("copy changed", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "report_changed": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("changed", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+}
+
+func Test_ReportChanged_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "", "report_changed": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("changed", res)
+	}))
+}
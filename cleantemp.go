@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// cleanTemp deletes regular files in the directory whose names match the
+// glob pattern and which haven't been modified for maxAge, such as temporary
+// files left behind by a crash. It returns the number of deleted files.
+// Files this session has open or speculated are never deleted.
+func (s *session) cleanTemp(dirPath, pattern string, maxAge time.Duration) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("cleanTemp took %s", time.Since(start))
+	}()
+
+	if pattern == "" || strings.ContainsRune(pattern, '/') {
+		return valFalse, fmt.Errorf("invalid pattern: %q", pattern)
+	}
+
+	if t := s.findSpeculativeDir(dirPath); t != nil && t.speculative {
+		return valFalse, fmt.Errorf("no such directory: %s", dirPath)
+	}
+
+	// Glob silently matches nothing in a missing directory.
+	st, err := os.Stat(dirPath)
+	if err != nil {
+		return valFalse, err
+	}
+	if !st.IsDir() {
+		return valFalse, fmt.Errorf("not a directory: %s", dirPath)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dirPath, pattern))
+	if err != nil {
+		return valFalse, err
+	}
+
+	threshold := time.Now().Add(-maxAge)
+	deleted := 0
+	for _, path := range paths {
+		if _, ok := s.openFiles[path]; ok {
+			continue
+		}
+		if _, found := s.speculativeExistence(path); found {
+			continue
+		}
+
+		fi, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return valFalse, err
+		}
+
+		if !fi.Mode().IsRegular() || fi.ModTime().After(threshold) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return valFalse, err
+		}
+		deleted++
+	}
+
+	return strconv.Itoa(deleted), nil
+}
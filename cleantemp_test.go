@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_CleanTemp(t *testing.T) {
+	age := func(p *testpack, name string) {
+		old := time.Now().Add(-time.Hour)
+		p.assert.NoError(os.Chtimes(p.fs.path(name), old, old))
+	}
+
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1 + "/.a.tmp").write(testContent1)
+		p.fs.file(testDir1 + "/.b.tmp").write(testContent1)
+		p.fs.file(testDir1 + "/.c.tmp").write(testContent1)
+		p.fs.file(testDir1File1).write(testContent1)
+		age(p, testDir1+"/.a.tmp")
+		age(p, testDir1+"/.b.tmp")
+		age(p, testDir1File1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "pattern": ".*.tmp", "older_than_ms": 60000}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("2", res)
+		p.assert.ElementsMatch([]string{".c.tmp", testFile1}, p.fs.dir(testDir1).ls())
+	}))
+
+	t.Run("directories kept", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1 + "/.d.tmp").create()
+		age(p, testDir1+"/.d.tmp")
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "pattern": ".*.tmp", "older_than_ms": 0}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("0", res)
+		p.assert.True(p.fs.dir(testDir1 + "/.d.tmp").exists())
+	}))
+
+	t.Run("pattern with separator", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "pattern": "../*", "older_than_ms": 0}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("no pattern", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "older_than_ms": 0}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "pattern": "*", "older_than_ms": 0}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_CleanTemp_Speculate(t *testing.T) {
+	t.Run("speculative and open files kept", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "mktemp": true}`, p.fs.path(testDir1)))
+		p.assert.NoError(err)
+		p.assert.NotEqual(testResFalse, res)
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "pattern": "*", "older_than_ms": 0}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("0", res)
+	}))
+}
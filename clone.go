@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	cloneReflink  = "reflink"
+	cloneHardlink = "hardlink"
+	cloneCopy     = "copy"
+)
+
+// isCloneUnsupported reports whether the error means the method can't be used
+// for the pair of files, rather than that the files are broken.
+func isCloneUnsupported(err error) bool {
+	return errors.Is(err, errUnsupported) ||
+		errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, syscall.ENOTTY) ||
+		errors.Is(err, syscall.EINVAL) ||
+		errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EMLINK)
+}
+
+// linkTempSibling creates a hidden hard link to src next to destPath.
+func linkTempSibling(srcPath, destPath string) (string, error) {
+	for i := 0; i < maxTempAttempts; i++ {
+		name := tempSiblingName(destPath)
+		err := os.Link(srcPath, name)
+		if os.IsExist(err) {
+			continue
+		}
+		return name, err
+	}
+
+	return "", fmt.Errorf("failed to create a temporary link for: %s", destPath)
+}
+
+// cloneFile duplicates src to dest as cheaply as possible: a reflink sharing
+// the blocks, a hard link on the same filesystem, or a full copy, in that
+// order. In verbose mode, the method used is returned instead of true.
+func (s *session) cloneFile(srcPath, destPath string, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("cloneFile took %s", time.Since(start))
+	}()
+
+	method, err := s.tryClone(srcPath, destPath, opts)
+	if err != nil {
+		if errors.Is(err, errExists) {
+			return valExists, nil
+		}
+		return valFalse, err
+	}
+
+	if s.cfg.verbose {
+		return method, nil
+	}
+
+	return valTrue, nil
+}
+
+func (s *session) tryClone(srcPath, destPath string, opts *writeOptions) (string, error) {
+	ok, err := s.tryReflink(srcPath, destPath, opts)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return cloneReflink, nil
+	}
+
+	// A hard link shares the mode and the owner, so changing them would also
+	// change src.
+	if opts.perm == nil && opts.uid == nil && opts.gid == nil {
+		ok, err := s.tryHardlink(srcPath, destPath, opts.overwrite)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return cloneHardlink, nil
+		}
+	}
+
+	res, err := s.copyFile(srcPath, destPath, opts)
+	if err != nil {
+		return "", err
+	}
+	if res == valExists {
+		return "", errExists
+	}
+
+	return cloneCopy, nil
+}
+
+// tryReflink reports false if the filesystem can't share blocks between the
+// files. dest is left as it was in that case.
+func (s *session) tryReflink(srcPath, destPath string, opts *writeOptions) (bool, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	srcStat, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.checkWriteSize(srcStat.Size()); err != nil {
+		return false, err
+	}
+
+	dest, created, err := s.createDest(destPath, opts)
+	if err != nil {
+		return false, err
+	}
+
+	err = reflink(dest, src)
+	if err == nil {
+		err = chownFile(dest, opts.uid, opts.gid)
+	}
+	if cerr := dest.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		return true, nil
+	}
+
+	// Don't leave behind an empty file for the fallbacks.
+	if created {
+		if err := os.Remove(destPath); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if isCloneUnsupported(err) {
+		log.Debugf("reflink unavailable: %s", err)
+		return false, nil
+	}
+
+	return false, err
+}
+
+// tryHardlink reports false if src can't be linked from the directory of dest,
+// such as when they are on different filesystems.
+func (s *session) tryHardlink(srcPath, destPath string, overwrite bool) (bool, error) {
+	// createDest has already claimed any speculative or open file at dest.
+	s.commitSpeculativeDir(filepath.Dir(destPath))
+
+	if !overwrite {
+		err := os.Link(srcPath, destPath)
+		if err == nil {
+			return true, nil
+		}
+		if os.IsExist(err) {
+			return false, errExists
+		}
+		if isCloneUnsupported(err) {
+			log.Debugf("hard link unavailable: %s", err)
+			return false, nil
+		}
+		return false, err
+	}
+
+	tmpPath, err := linkTempSibling(srcPath, destPath)
+	if err != nil {
+		if isCloneUnsupported(err) {
+			log.Debugf("hard link unavailable: %s", err)
+			return false, nil
+		}
+		return false, err
+	}
+
+	// Renaming a link over another link to the same file does nothing,
+	// so the temporary name may remain.
+	defer func() {
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+	}()
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink makes dest share the blocks of src with the FICLONE ioctl.
+func reflink(dest, src *os.File) error {
+	return unix.IoctlFileClone(int(dest.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+func reflink(dest, src *os.File) error {
+	return errUnsupported
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Clone(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("verbose", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Contains([]string{"reflink", "hardlink"}, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("perm never links", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+		p.fs.file(testFile1).write(testContent1).chmod(testFilePerm2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2),
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Contains([]string{"reflink", "copy"}, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile2).mode())
+		p.assert.Equal(testFilePerm2, p.fs.file(testFile1).mode())
+	}))
+
+	t.Run("overwrite", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("already linked", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+		p.fs.file(testFile1).write(testContent1)
+
+		for i := 0; i < 2; i++ {
+			_, err := p.sess.addTask(taskf(
+				`{"src": "%s", "dest": "%s", "clone": true}`,
+				p.fs.path(testFile1),
+				p.fs.path(testFile2)))
+			p.assert.NoError(err)
+		}
+
+		names, err := p.sess.listDir(filepath.Clean(p.fs.path(".")), filterAll, time.Time{})
+		p.assert.NoError(err)
+		p.assert.ElementsMatch([]string{testFile1, testFile2}, names)
+	}))
+
+	t.Run("without overwrite", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true, "overwrite": false}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("inexistent src", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+}
+
+func Test_Clone_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+}
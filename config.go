@@ -0,0 +1,117 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// config holds the daemon settings applied to every session.
+type config struct {
+	// maxSpeculations caps the number of live speculative files per session.
+	// Zero means unlimited.
+	maxSpeculations int
+
+	// root is the absolute base directory against which relative paths are
+	// resolved. Empty means the working directory of the daemon.
+	root string
+
+	// verbose makes responses more descriptive for integrators.
+	verbose bool
+
+	// lenientJSON ignores unknown task fields instead of rejecting them.
+	lenientJSON bool
+
+	// sharedSpeculation lets sessions claim speculative files made by other
+	// sessions. See speculationPool for the lifecycle.
+	sharedSpeculation bool
+
+	// allowPrefixes restricts paths to these clean absolute directories.
+	// Empty means no restriction.
+	allowPrefixes []string
+
+	// idleTimeout closes connections that receive nothing for this long.
+	// Zero means never.
+	idleTimeout time.Duration
+
+	// maxWriteBytes caps the size of a file written by a single task.
+	// Zero means unlimited.
+	maxWriteBytes int64
+
+	// copyBufferSize is the size of the buffer used to copy file content.
+	copyBufferSize int
+
+	// speculateConcurrency caps concurrent speculative opens per session.
+	// Zero means unlimited.
+	speculateConcurrency int
+
+	// cleanConcurrency caps the goroutines per directory disposing unused
+	// speculations. Zero means unlimited.
+	cleanConcurrency int
+
+	// debug enables introspection tasks not meant for production.
+	debug bool
+
+	// logSample logs only 1 in logSample requests and responses.
+	// Zero or one means every request.
+	logSample int
+
+	// quiet suppresses logs of requests and responses.
+	quiet bool
+
+	// maxLogContent truncates logged requests and responses to this many
+	// bytes. Zero means unlimited.
+	maxLogContent int
+
+	// noSpeculation makes "speculate" a no-op so that every write opens the
+	// file on demand.
+	noSpeculation bool
+
+	// makeParents is the default of "make_parents" on create and copy.
+	makeParents bool
+
+	// adminEnabled allows "admin" tasks changing the daemon at runtime.
+	adminEnabled bool
+
+	// logLevel is the level of the logger, applied at start and on reload.
+	logLevel log.Level
+
+	// maxInFlight makes tasks fail fast with "busy" while this many tasks
+	// and speculative opens are in flight daemon-wide. Zero means unlimited.
+	maxInFlight int64
+
+	// dirCacheSize is the number of directory existence checks each session
+	// remembers. Zero disables the cache so that every check stats.
+	dirCacheSize int
+
+	// maxContentMemory bounds the total bytes of task inputs processed at
+	// once daemon-wide. It's fixed at start. Zero means unlimited.
+	maxContentMemory int64
+}
+
+func defaultConfig() *config {
+	return &config{
+		maxSpeculations:      0,
+		root:                 "",
+		verbose:              false,
+		lenientJSON:          false,
+		sharedSpeculation:    false,
+		allowPrefixes:        nil,
+		idleTimeout:          0,
+		maxWriteBytes:        0,
+		copyBufferSize:       64 * 1024,
+		speculateConcurrency: 0,
+		cleanConcurrency:     0,
+		debug:                false,
+		logSample:            1,
+		quiet:                false,
+		maxLogContent:        1000,
+		noSpeculation:        false,
+		makeParents:          false,
+		adminEnabled:         false,
+		maxInFlight:          0,
+		dirCacheSize:         0,
+		maxContentMemory:     0,
+		logLevel:             log.InfoLevel,
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type copyRecursiveResult struct {
+	Copied  int `json:"copied"`  // Regular files.
+	Skipped int `json:"skipped"` // Filtered out, or neither a regular file nor a directory.
+}
+
+// copyFilter decides which entries copy_recursive copies. Globs match either
+// the base name or the path relative to the root of the copy.
+type copyFilter struct {
+	include      []string // Files matching none are skipped, unless empty.
+	exclude      []string // Matching files are skipped and directories pruned.
+	maxFileBytes int64    // Larger files are skipped. Negative means no limit.
+}
+
+func newCopyFilter(include, exclude []string, maxFileBytes *int64) (*copyFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s: %w", pattern, err)
+		}
+	}
+
+	f := &copyFilter{include: include, exclude: exclude, maxFileBytes: -1}
+	if maxFileBytes != nil {
+		if *maxFileBytes < 0 {
+			return nil, fmt.Errorf("invalid max_file_bytes: %d", *maxFileBytes)
+		}
+		f.maxFileBytes = *maxFileBytes
+	}
+
+	return f, nil
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skips reports whether the entry at rel is left out of the copy.
+func (f *copyFilter) skips(rel string, fi os.FileInfo) bool {
+	if matchAny(f.exclude, rel) {
+		return true
+	}
+
+	if fi.IsDir() {
+		return false
+	}
+
+	if len(f.include) != 0 && !matchAny(f.include, rel) {
+		return true
+	}
+
+	return 0 <= f.maxFileBytes && fi.Mode().IsRegular() && f.maxFileBytes < fi.Size()
+}
+
+// copyRecursive copies the src directory into dest, leaving out what the
+// filter skips. Existing files are overwritten and nothing is deleted.
+func (s *session) copyRecursive(srcPath, destPath string, filter *copyFilter, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("copyRecursive took %s", time.Since(start))
+	}()
+
+	entries, filtered, err := s.walkSource(srcPath, destPath, filter.skips)
+	if err != nil {
+		return valFalse, err
+	}
+
+	copied, skipped, err := s.copyEntries(srcPath, destPath, entries, opts, nil)
+	if err != nil {
+		return valFalse, err
+	}
+
+	j, err := json.Marshal(&copyRecursiveResult{Copied: copied, Skipped: filtered + skipped})
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
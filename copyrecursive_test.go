@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func Test_CopyRecursive(t *testing.T) {
+	// Copies subdir, where test2.txt is larger than the other files, and
+	// returns the number of skipped entries and what "walk" lists in dest.
+	copyFiltered := func(p *testpack, params string) (int, []string) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1File2).write(testContent1 + testContent1)
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_recursive": true, %s}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2),
+			params))
+		p.assert.NoError(err)
+
+		r := &copyRecursiveResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true}`,
+			p.fs.path(testDir2)))
+		p.assert.NoError(err)
+
+		var paths []string
+		p.assert.NoError(json.Unmarshal([]byte(res), &paths))
+		return r.Skipped, paths
+	}
+
+	t.Run("base name excluded at any depth", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, `"exclude": ["test.txt"]`)
+
+		p.assert.Equal(2, skipped)
+		p.assert.Equal([]string{"anotherdir", "test2.txt"}, paths)
+	}))
+
+	t.Run("directory pruned by base name", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, `"exclude": ["anotherdir"]`)
+
+		p.assert.Equal(1, skipped)
+		p.assert.Equal([]string{"test.txt", "test2.txt"}, paths)
+	}))
+
+	t.Run("relative path excluded", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, `"exclude": ["anotherdir/test.txt"]`)
+
+		p.assert.Equal(1, skipped)
+		p.assert.Equal([]string{"anotherdir", "test.txt", "test2.txt"}, paths)
+	}))
+
+	t.Run("include doesn't prune directories", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, `"include": ["test.txt"]`)
+
+		p.assert.Equal(1, skipped)
+		p.assert.Equal([]string{"anotherdir", "anotherdir/test.txt", "test.txt"}, paths)
+	}))
+
+	t.Run("include by relative path", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, `"include": ["anotherdir/*"]`)
+
+		p.assert.Equal(2, skipped)
+		p.assert.Equal([]string{"anotherdir", "anotherdir/test.txt"}, paths)
+	}))
+
+	t.Run("exclude wins over include", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, `"include": ["*.txt"], "exclude": ["anotherdir"]`)
+
+		p.assert.Equal(1, skipped)
+		p.assert.Equal([]string{"test.txt", "test2.txt"}, paths)
+	}))
+
+	t.Run("max file bytes", run(func(p *testpack) {
+		skipped, paths := copyFiltered(p, fmt.Sprintf(`"max_file_bytes": %d`, len(testContent1)))
+
+		p.assert.Equal(1, skipped)
+		p.assert.Equal([]string{"anotherdir", "anotherdir/test.txt", "test.txt"}, paths)
+	}))
+
+	t.Run("invalid pattern", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_recursive": true, "exclude": ["["]}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.dir(testDir2).exists())
+	}))
+
+	t.Run("negative max file bytes", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_recursive": true, "max_file_bytes": -1}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
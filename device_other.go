@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+func statDevice(fi os.FileInfo) (uint64, error) {
+	return 0, errUnsupported
+}
+
+func statInode(fi os.FileInfo) (uint64, error) {
+	return 0, errUnsupported
+}
+
+func statOwner(fi os.FileInfo) (int, int, error) {
+	return 0, 0, errUnsupported
+}
+
+func statNlink(fi os.FileInfo) (uint64, error) {
+	return 0, errUnsupported
+}
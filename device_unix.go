@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statDevice returns the device number of the file.
+func statDevice(fi os.FileInfo) (uint64, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errUnsupported
+	}
+
+	return uint64(st.Dev), nil
+}
+
+// statInode returns the inode number of the file.
+func statInode(fi os.FileInfo) (uint64, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errUnsupported
+	}
+
+	return uint64(st.Ino), nil
+}
+
+// statOwner returns the owner and the group of the file.
+func statOwner(fi os.FileInfo) (int, int, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, errUnsupported
+	}
+
+	return int(st.Uid), int(st.Gid), nil
+}
+
+// statNlink returns the number of hard links to the file.
+func statNlink(fi os.FileInfo) (uint64, error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errUnsupported
+	}
+
+	return uint64(st.Nlink), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirCache remembers the results of recent existence checks of directories
+// and missing paths, so that repeated checks of the same parents don't stat
+// the filesystem again. Regular files aren't cached.
+// A nil dirCache caches nothing.
+type dirCache struct {
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // Least recently used first.
+}
+
+type dirCacheEntry struct {
+	path   string
+	exists bool
+}
+
+// newDirCache returns a cache of up to size paths, or nil if size isn't
+// positive.
+func newDirCache(size int) *dirCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &dirCache{
+		size:    size,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// get returns the cached existence of path. ok is false on a miss.
+func (c *dirCache) get(path string) (exists bool, ok bool) {
+	if c == nil {
+		return false, false
+	}
+
+	e, ok := c.entries[path]
+	if !ok {
+		return false, false
+	}
+
+	c.lru.MoveToBack(e)
+	return e.Value.(*dirCacheEntry).exists, true
+}
+
+// put records the existence of path, evicting the least recently used entry
+// if the cache is full.
+func (c *dirCache) put(path string, exists bool) {
+	if c == nil {
+		return
+	}
+
+	if e, ok := c.entries[path]; ok {
+		e.Value.(*dirCacheEntry).exists = exists
+		c.lru.MoveToBack(e)
+		return
+	}
+
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Front()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dirCacheEntry).path)
+	}
+
+	c.entries[path] = c.lru.PushBack(&dirCacheEntry{path: path, exists: exists})
+}
+
+// record caches the result of os.Stat on path if it's a directory or
+// nothing at all.
+func (c *dirCache) record(path string, fi os.FileInfo, err error) {
+	switch {
+	case err == nil && fi.IsDir():
+		c.put(path, true)
+	case os.IsNotExist(err):
+		c.put(path, false)
+	}
+}
+
+// invalidate forgets path and everything under it, which a mutation may have
+// created or removed. Ancestors cached as missing are forgotten too because
+// the mutation may have created them.
+func (c *dirCache) invalidate(path string) {
+	if c == nil {
+		return
+	}
+
+	prefix := path + "/"
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		entry := e.Value.(*dirCacheEntry)
+		if entry.path == path || strings.HasPrefix(entry.path, prefix) {
+			c.remove(e)
+		}
+		e = next
+	}
+
+	for p := filepath.Dir(path); ; p = filepath.Dir(p) {
+		if e, ok := c.entries[p]; ok && !e.Value.(*dirCacheEntry).exists {
+			c.remove(e)
+		}
+
+		if p == filepath.Dir(p) {
+			return
+		}
+	}
+}
+
+// clear forgets everything.
+func (c *dirCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+}
+
+func (c *dirCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*dirCacheEntry).path)
+}
+
+// readOnly reports whether the task never changes the filesystem, so that it
+// can't make cached existence stale.
+func (t *task) readOnly() bool {
+	return t.Existence || t.ExistenceMany != nil || t.FirstExisting != nil ||
+		t.ListDir || t.ListDirDirs || t.ListDirFiles || t.ListDirStream || t.Walk ||
+		t.Stats || t.Hello || t.ReadHead != nil || t.ReadRange || t.TreeHash ||
+		t.Inode || t.Nlink || t.Realpath || t.InRoot || t.Statfs || t.IsMountpoint ||
+		t.DumpTree || t.ListSpeculations || t.WaitSize != nil ||
+		(t.VerifySHA256 != nil && t.SourcePath == nil && t.Content == nil) ||
+		t.Speculate || t.SpeculateAlias
+}
+
+// invalidateDirCache forgets cached existence the task may make stale.
+// It's called before the task runs; the cache is only read by this session.
+func (s *session) invalidateDirCache(t *task) {
+	if s.dirCache == nil || t.readOnly() {
+		return
+	}
+
+	// These touch paths other than "dest", "src", and "open".
+	if t.MoveMany != nil || t.MkdirMany != nil || t.RollbackTx || t.Untar != nil ||
+		t.Admin != nil || t.AppendRotate || t.Backup || t.PruneEmptyParents {
+		s.dirCache.clear()
+		return
+	}
+
+	paths := []string{t.Destination}
+	if t.SourcePath != nil {
+		paths = append(paths, *t.SourcePath)
+	}
+	if t.Open != nil {
+		paths = append(paths, *t.Open)
+	}
+
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+
+		path, err := s.resolvePath(p)
+		if err != nil {
+			continue
+		}
+		s.dirCache.invalidate(path)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_DirCache(t *testing.T) {
+	cached := func(p *testpack, size int) {
+		cfg := defaultConfig()
+		cfg.dirCacheSize = size
+		p.sess = newSession(cfg)
+	}
+
+	existence := func(p *testpack, path string) string {
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "existence": true}`, p.fs.path(path)))
+		p.assert.NoError(err)
+		return res
+	}
+
+	t.Run("directory cached", run(func(p *testpack) {
+		cached(p, 8)
+		p.fs.dir(testDir1).create()
+
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+		p.assert.NoError(os.Remove(p.fs.path(testDir1)))
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+	}))
+
+	t.Run("disabled by default", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+		p.assert.NoError(os.Remove(p.fs.path(testDir1)))
+		p.assert.Equal(testResFalse, existence(p, testDir1))
+	}))
+
+	t.Run("file not cached", run(func(p *testpack) {
+		cached(p, 8)
+		p.fs.file(testFile1).write(testContent1)
+
+		p.assert.Equal(testResTrue, existence(p, testFile1))
+		p.assert.NoError(os.Remove(p.fs.path(testFile1)))
+		p.assert.Equal(testResFalse, existence(p, testFile1))
+	}))
+
+	t.Run("descendants invalidated", run(func(p *testpack) {
+		cached(p, 8)
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+
+		p.assert.Equal(testResTrue, existence(p, testDir1Dir2))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete_recursive": true}`,
+			p.fs.path(testDir1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testResFalse, existence(p, testDir1Dir2))
+	}))
+
+	t.Run("missing ancestors invalidated", run(func(p *testpack) {
+		cached(p, 8)
+
+		p.assert.Equal(testResFalse, existence(p, testDir1))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "make_parents": true}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+	}))
+
+	t.Run("pruned parents invalidated", run(func(p *testpack) {
+		cached(p, 8)
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true, "prune_empty_parents": true}`,
+			p.fs.path(testDir1File1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testResFalse, existence(p, testDir1))
+	}))
+
+	t.Run("existence_many", run(func(p *testpack) {
+		cached(p, 8)
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"existence_many": ["%s"]}`,
+			p.fs.path(testDir1)))
+		p.assert.NoError(err)
+		p.assert.Equal(`[true]`, res)
+
+		p.assert.NoError(os.Remove(p.fs.path(testDir1)))
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+	}))
+
+	t.Run("not used inside mutating tasks", run(func(p *testpack) {
+		cached(p, 8)
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sync": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+		p.assert.NoError(err)
+		p.assert.Equal(`{"copied":1,"unchanged":0,"deleted":0,"skipped":0}`, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir2+"/"+testDir2+"/"+testFile1).read())
+	}))
+
+	t.Run("blocked path counted once", run(func(p *testpack) {
+		cached(p, 8)
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		before := metrics.blockedRequests.Load()
+
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal(before+1, metrics.blockedRequests.Load())
+	}))
+
+	t.Run("least recently used evicted", run(func(p *testpack) {
+		cached(p, 1)
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir2).create()
+
+		p.assert.Equal(testResTrue, existence(p, testDir1))
+		p.assert.Equal(testResTrue, existence(p, testDir2))
+		p.assert.NoError(os.Remove(p.fs.path(testDir1)))
+		p.assert.Equal(testResFalse, existence(p, testDir1))
+	}))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type fileDump struct {
+	Name  string `json:"name"`
+	IsNew bool   `json:"is_new"`
+	Error string `json:"error,omitempty"`
+}
+
+type treeDump struct {
+	Name        string      `json:"name"`
+	Speculative bool        `json:"speculative"`
+	Dirs        []*treeDump `json:"dirs"`
+	Files       []*fileDump `json:"files"`
+}
+
+// dump returns the snapshot of the tree with children sorted by name.
+// It waits for pending speculative opens.
+func (t *dirTree) dump() *treeDump {
+	d := &treeDump{
+		Name:        t.name,
+		Speculative: t.speculative,
+		Dirs:        []*treeDump{},
+		Files:       []*fileDump{},
+	}
+
+	for _, c := range t.childDirs {
+		d.Dirs = append(d.Dirs, c.dump())
+	}
+	sort.Slice(d.Dirs, func(i, j int) bool { return d.Dirs[i].Name < d.Dirs[j].Name })
+
+	for name, f := range t.childFiles {
+		// Claimed by another session.
+		if f.claimed.Load() {
+			continue
+		}
+
+		fut := f.getFutureFile()
+		fd := &fileDump{
+			Name:  name,
+			IsNew: fut.isNew,
+		}
+		if fut.err != nil {
+			fd.Error = fut.err.Error()
+		}
+		d.Files = append(d.Files, fd)
+	}
+	sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].Name < d.Files[j].Name })
+
+	return d
+}
+
+// dumpTree serializes the speculative tree for debugging.
+// It's available only in debug mode.
+func (s *session) dumpTree() (string, error) {
+	if !s.cfg.debug {
+		return valInvalid, fmt.Errorf("dump_tree requires --debug")
+	}
+
+	j, err := json.Marshal(s.speculativeDirTree.dump())
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
+
+type speculationEntry struct {
+	Path  string `json:"path"`
+	IsNew bool   `json:"is_new"`
+	Error string `json:"error,omitempty"`
+}
+
+// listSpeculations returns the live speculative files of the session sorted
+// by path, so that clients can find what they over-speculated. Unlike
+// dumpTree, it's flat and always available. It waits for pending opens.
+func (s *session) listSpeculations() (string, error) {
+	entries := []*speculationEntry{}
+	s.speculativeDirTree.forEachFile(func(f *speculativeFile) {
+		// Claimed by another session.
+		if f.claimed.Load() {
+			return
+		}
+
+		fut := f.getFutureFile()
+		e := &speculationEntry{
+			Path:  f.parent.getPath() + "/" + f.name,
+			IsNew: fut.isNew,
+		}
+		if fut.err != nil {
+			e.Error = fut.err.Error()
+		}
+		entries = append(entries, e)
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	j, err := json.Marshal(entries)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_DumpTree(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.sess.cfg.debug = true
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask([]byte(`{"dump_tree": true}`))
+		p.assert.NoError(err)
+
+		var root treeDump
+		p.assert.NoError(json.Unmarshal([]byte(res), &root))
+
+		base := &root
+		for _, name := range strings.Split(filepath.Clean(p.fs.path(testRootDir))[1:], "/") {
+			p.assert.Len(base.Dirs, 1)
+			base = base.Dirs[0]
+			p.assert.Equal(name, base.Name)
+		}
+
+		p.assert.Len(base.Files, 1)
+		p.assert.Equal(testFile1, base.Files[0].Name)
+		p.assert.False(base.Files[0].IsNew)
+
+		p.assert.Len(base.Dirs, 1)
+		p.assert.Equal(testDir1, base.Dirs[0].Name)
+		p.assert.True(base.Dirs[0].Speculative)
+		p.assert.True(base.Dirs[0].Files[0].IsNew)
+	}))
+
+	t.Run("disabled", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"dump_tree": true}`))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+}
+
+func Test_ListSpeculations(t *testing.T) {
+	list := func(p *testpack) []*speculationEntry {
+		res, err := p.sess.addTask([]byte(`{"list_speculations": true}`))
+		p.assert.NoError(err)
+
+		var entries []*speculationEntry
+		p.assert.NoError(json.Unmarshal([]byte(res), &entries))
+		return entries
+	}
+
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.Equal([]*speculationEntry{
+			{Path: filepath.Clean(p.fs.path(testDir1File1)), IsNew: true},
+			{Path: filepath.Clean(p.fs.path(testFile1)), IsNew: false},
+		}, list(p))
+	}))
+
+	t.Run("claimed excluded", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.Empty(list(p))
+	}))
+
+	t.Run("none", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"list_speculations": true}`))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[]", res)
+	}))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// emptyDir removes the children of the directory while keeping the directory
+// itself, and returns the number of children removed. Non-empty child
+// directories are removed only if recursive is set.
+func (s *session) emptyDir(dirPath string, recursive bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("emptyDir took %s", time.Since(start))
+	}()
+
+	tree, exists := s.walkRoot(dirPath)
+	if !exists {
+		return valFalse, fmt.Errorf("no such directory: %s", dirPath)
+	}
+
+	fi, err := os.Stat(dirPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if !fi.IsDir() {
+		return valFalse, fmt.Errorf("not a directory: %s", dirPath)
+	}
+
+	var names []string
+	if tree != nil {
+		names, err = tree.logicalList()
+	} else {
+		names, err = readDirNames(dirPath)
+	}
+	if err != nil {
+		return valFalse, err
+	}
+
+	var removed atomic.Int64
+	eg := &errgroup.Group{}
+	for _, n := range names {
+		n := n
+		var f *speculativeFile
+		speculated := false
+		if tree != nil {
+			var ok bool
+			f, ok = tree.file(n)
+			speculated = ok && f.withdraw()
+		}
+		eg.Go(func() error {
+			if tree != nil {
+				if d, ok := tree.childDirs[n]; ok {
+					succeeded, err := d.delete(recursive, nil)
+					if err != nil {
+						return err
+					}
+
+					if succeeded {
+						removed.Add(1)
+					}
+					return nil
+				}
+
+				// Left for finalize to remove.
+				if speculated {
+					f.getFutureFile().isNew = true
+					removed.Add(1)
+					return nil
+				}
+			}
+
+			if err := concurrentRemove(dirPath+"/"+n, recursive, nil); err != nil {
+				return err
+			}
+
+			removed.Add(1)
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return valFalse, err
+	}
+
+	return strconv.FormatInt(removed.Load(), 10), nil
+}
+
+func readDirNames(dirPath string) ([]string, error) {
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
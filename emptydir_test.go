@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_EmptyDir(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1File2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "empty_dir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("2", res)
+		p.assert.True(p.fs.dir(testDir1).exists())
+		p.assert.False(p.fs.file(testDir1File1).exists())
+		p.assert.False(p.fs.file(testDir1File2).exists())
+	}))
+
+	t.Run("nested without recursive", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "empty_dir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(testDir1Dir2File1).exists())
+	}))
+
+	t.Run("nested with recursive", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "empty_dir": true, "recursive": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("2", res)
+		p.assert.True(p.fs.dir(testDir1).exists())
+		p.assert.False(p.fs.dir(testDir1Dir2).exists())
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "empty_dir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_EmptyDir_Speculate(t *testing.T) {
+	t.Run("speculative files", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "empty_dir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("1", res)
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "existence": true}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+
+		p.sess.finalize()
+		p.assert.True(p.fs.dir(testDir1).exists())
+		p.assert.False(p.fs.file(testDir1File1).exists())
+		p.assert.False(p.fs.file(testDir1File2).exists())
+	}))
+}
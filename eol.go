@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxNormalizeEOLBytes caps the source of normalize_eol, which is held in
+// memory as a whole.
+const maxNormalizeEOLBytes = 1024 * 1024
+
+// ensureTrailingNewline returns data ending with a newline, appending one
+// only if missing.
+func ensureTrailingNewline(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\n' {
+		return data
+	}
+
+	return append(data, '\n')
+}
+
+// normalizeEOL rewrites every line ending in data to eol, "lf" or "crlf".
+// A lone CR isn't a line ending.
+func normalizeEOL(data []byte, eol string) ([]byte, error) {
+	lf := bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	switch eol {
+	case "lf":
+		return lf, nil
+	case "crlf":
+		return bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n")), nil
+	default:
+		return nil, fmt.Errorf("unknown normalize_eol: %s", eol)
+	}
+}
+
+// copyNormalizeEOL writes the small text file src to dest with line endings
+// rewritten. Binaries, detected by a null byte, are refused rather than
+// corrupted.
+func (s *session) copyNormalizeEOL(srcPath, destPath, eol string, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("copyNormalizeEOL took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(srcPath); found && !exists {
+		return valFalse, fmt.Errorf("no such file: %s", srcPath)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return valFalse, err
+	}
+	defer src.Close()
+
+	// Read one byte more than the limit to detect a larger file.
+	content, err := io.ReadAll(io.LimitReader(src, maxNormalizeEOLBytes+1))
+	if err != nil {
+		return valFalse, err
+	}
+
+	if maxNormalizeEOLBytes < len(content) {
+		return valFalse, fmt.Errorf("source exceeds %d bytes: %s", maxNormalizeEOLBytes, srcPath)
+	}
+
+	if bytes.IndexByte(content, 0) != -1 {
+		return valFalse, fmt.Errorf("refusing to normalize a binary file: %s", srcPath)
+	}
+
+	normalized, err := normalizeEOL(content, eol)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return s.createFile(normalized, destPath, opts)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_EnsureTrailingNewline(t *testing.T) {
+	create := func(p *testpack, content, params string) (string, error) {
+		return p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "ensure_trailing_newline": true%s}`,
+			p.fs.path(testFile1),
+			b64String(content),
+			params))
+	}
+
+	t.Run("appended", run(func(p *testpack) {
+		res, err := create(p, testContent1, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1+"\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("already present", run(func(p *testpack) {
+		res, err := create(p, testContent1+"\n", "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1+"\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("empty", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "", "ensure_trailing_newline": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("compared after appending", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1 + "\n")
+
+		res, err := create(p, testContent1, `, "skip_unchanged": true`)
+
+		p.assert.NoError(err)
+		p.assert.Equal("unchanged", res)
+	}))
+
+	t.Run("copy untouched", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "ensure_trailing_newline": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_NormalizeEOL(t *testing.T) {
+	normalize := func(p *testpack, eol string) (string, error) {
+		return p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "normalize_eol": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2),
+			eol))
+	}
+
+	t.Run("lf", run(func(p *testpack) {
+		p.fs.file(testFile2).write("a\r\nb\nc\rd\r\n")
+
+		res, err := normalize(p, "lf")
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("a\nb\nc\rd\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("crlf", run(func(p *testpack) {
+		p.fs.file(testFile2).write("a\r\nb\nc")
+
+		res, err := normalize(p, "crlf")
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("a\r\nb\r\nc", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("binary", run(func(p *testpack) {
+		p.fs.file(testFile2).write("a\r\n\x00b")
+
+		res, err := normalize(p, "lf")
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("unknown", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := normalize(p, "cr")
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("too large", run(func(p *testpack) {
+		p.fs.file(testFile2).write(strings.Repeat("a", maxNormalizeEOLBytes+1))
+
+		res, err := normalize(p, "lf")
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
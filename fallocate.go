@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// extend grows the file to the size without reserving blocks, which is the
+// best available where fallocate is unsupported. It never shrinks the file.
+func extend(file *os.File, size int64) error {
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if size <= st.Size() {
+		return nil
+	}
+
+	return file.Truncate(size)
+}
+
+// fallocate reserves space for the file, creating it if absent.
+func (s *session) fallocate(destPath string, size int64, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("fallocate took %s", time.Since(start))
+	}()
+
+	if size < 0 {
+		return valFalse, fmt.Errorf("negative size: %d", size)
+	}
+
+	if err := s.checkWriteSize(size); err != nil {
+		return valFalse, err
+	}
+
+	dest, created, err := s.createDest(destPath, opts)
+	if err != nil {
+		if errors.Is(err, errExists) {
+			return valExists, nil
+		}
+		return valFalse, err
+	}
+	defer func() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := dest.Close(); err != nil {
+				log.Errorf("failed to close: %s", destPath)
+			}
+		}()
+	}()
+
+	if err := preallocate(dest, size); err != nil {
+		removeIfNoSpace(destPath, created, err)
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
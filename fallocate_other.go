@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+func preallocate(file *os.File, size int64) error {
+	return extend(file, size)
+}
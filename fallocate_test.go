@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func sizeOf(p *testpack, path string) int64 {
+	st, err := os.Stat(path)
+	p.assert.NoError(err)
+	return st.Size()
+}
+
+func Test_Fallocate(t *testing.T) {
+	t.Run("new file", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fallocate": 4096}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(int64(4096), sizeOf(p, p.fs.path(testFile1)))
+	}))
+
+	t.Run("existing content kept", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fallocate": 1}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("negative", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fallocate": -1}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+}
+
+func Test_Fallocate_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fallocate": 4096}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(int64(4096), sizeOf(p, p.fs.path(testDir1File1)))
+	}))
+}
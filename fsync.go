@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fsyncDir makes the entries of the directory durable.
+func (s *session) fsyncDir(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("fsyncDir took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	dir, err := os.Open(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+	defer dir.Close()
+
+	fi, err := dir.Stat()
+	if err != nil {
+		return valFalse, err
+	}
+
+	if !fi.IsDir() {
+		return valFalse, fmt.Errorf("not a directory: %s", destPath)
+	}
+
+	if err := dir.Sync(); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func Test_FsyncDir(t *testing.T) {
+	t.Run("directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fsync_dir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fsync_dir": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "fsync_dir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+// failSyncWithNoSpace makes syncs fail with ENOSPC as reported late by the
+// OS until the returned function is called.
+func failSyncWithNoSpace() func() {
+	orig := syncFile
+	syncFile = func(file *os.File) error {
+		return syscall.ENOSPC
+	}
+
+	return func() {
+		syncFile = orig
+	}
+}
+
+func Test_Fsync(t *testing.T) {
+	t.Run("create", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "fsync": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("copy shorter", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "fsync": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("create error", run(func(p *testpack) {
+		defer failSyncWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "fsync": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("copy error", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		defer failSyncWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "fsync": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("atomic error", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent2)
+
+		defer failSyncWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "fsync": true, "atomic": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("not asked", run(func(p *testpack) {
+		defer failSyncWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+}
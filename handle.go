@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func newHandleToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// openHandle opens the file for appending and keeps it open under a token
+// returned as a JSON string, until closeHandle or finalize.
+func (s *session) openHandle(path string, perm *os.FileMode) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("openHandle took %s", time.Since(start))
+	}()
+
+	flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+
+	deleted, err := s.takeOverDest(path)
+	if err != nil {
+		return valFalse, err
+	}
+	if deleted {
+		flag |= os.O_TRUNC
+	}
+
+	newPerm := os.FileMode(0666)
+	if perm != nil {
+		newPerm = *perm
+	}
+
+	file, err := os.OpenFile(path, flag, newPerm)
+	if err != nil {
+		return valFalse, err
+	}
+
+	token, err := newHandleToken()
+	if err != nil {
+		file.Close()
+		return valFalse, err
+	}
+	s.handles[token] = file
+
+	j, err := json.Marshal(token)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
+
+func (s *session) appendHandle(token string, content []byte) (string, error) {
+	file, ok := s.handles[token]
+	if !ok {
+		return valFalse, fmt.Errorf("no such handle: %s", token)
+	}
+
+	if err := s.checkWriteSize(int64(len(content))); err != nil {
+		return valFalse, err
+	}
+
+	if _, err := writeFile(file, content); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
+
+func (s *session) closeHandle(token string) (string, error) {
+	file, ok := s.handles[token]
+	if !ok {
+		return valFalse, fmt.Errorf("no such handle: %s", token)
+	}
+	delete(s.handles, token)
+
+	if err := file.Close(); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
+
+func (s *session) closeHandles() {
+	for _, file := range s.handles {
+		if err := file.Close(); err != nil {
+			log.Errorf("failed to close: %s", file.Name())
+		}
+	}
+
+	s.handles = map[string]*os.File{}
+}
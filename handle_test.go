@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func openHandleTask(p *testpack, path string) string {
+	res, err := p.sess.addTask(taskf(`{"open": "%s"}`, path))
+	p.assert.NoError(err)
+
+	var token string
+	p.assert.NoError(json.Unmarshal([]byte(res), &token))
+	return token
+}
+
+func Test_Handle(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		token := openHandleTask(p, p.fs.path(testFile1))
+
+		for _, c := range []string{testContent2, testContent1} {
+			res, err := p.sess.addTask(taskf(
+				`{"handle": "%s", "append_b64": "%s"}`,
+				token,
+				b64String(c)))
+
+			p.assert.NoError(err)
+			p.assert.Equal(testResTrue, res)
+		}
+
+		res, err := p.sess.addTask(taskf(`{"close_handle": "%s"}`, token))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1+testContent2+testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("new file", run(func(p *testpack) {
+		token := openHandleTask(p, p.fs.path(testFile1))
+
+		p.sess.addTask(taskf(
+			`{"handle": "%s", "append_b64": "%s"}`,
+			token,
+			b64String(testContent1)))
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("unknown handle", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"handle": "%s", "append_b64": "%s"}`,
+			"unknown",
+			b64String(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("closed handle", run(func(p *testpack) {
+		token := openHandleTask(p, p.fs.path(testFile1))
+		p.sess.addTask(taskf(`{"close_handle": "%s"}`, token))
+
+		res, err := p.sess.addTask(taskf(`{"close_handle": "%s"}`, token))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Handle_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		token := openHandleTask(p, p.fs.path(testDir1File1))
+		p.sess.addTask(taskf(
+			`{"handle": "%s", "append_b64": "%s"}`,
+			token,
+			b64String(testContent1)))
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("speculatively deleted file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true}`,
+			p.fs.path(testFile1)))
+
+		token := openHandleTask(p, p.fs.path(testFile1))
+		p.sess.addTask(taskf(
+			`{"handle": "%s", "append_b64": "%s"}`,
+			token,
+			b64String(testContent2)))
+
+		p.sess.finalize()
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// treeHashConcurrency caps the concurrent file reads of a tree_hash task.
+const treeHashConcurrency = 16
+
+func fileSHA256(path string, bufSize int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyBuffer(h, file, make([]byte, bufSize)); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// matchesSHA256 reports whether the file matches the expected hex digest.
+func (s *session) matchesSHA256(path, expected string) (bool, error) {
+	want, err := hex.DecodeString(expected)
+	if err != nil {
+		return false, fmt.Errorf("invalid digest: %w", err)
+	}
+
+	if exists, found := s.speculativeExistence(path); found && !exists {
+		return false, fmt.Errorf("no such file: %s", path)
+	}
+
+	got, err := fileSHA256(path, s.cfg.copyBufferSize)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(want, got), nil
+}
+
+// verifySHA256 reports whether the file matches the expected hex digest.
+func (s *session) verifySHA256(destPath, expected string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("verifySHA256 took %s", time.Since(start))
+	}()
+
+	ok, err := s.matchesSHA256(destPath, expected)
+	if err != nil || !ok {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
+
+// copyVerified copies src to dest only if src matches the expected digest.
+// src is hashed before copying so that dest is never touched on a mismatch,
+// at the cost of reading src twice.
+func (s *session) copyVerified(srcPath, destPath, expected string, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("copyVerified took %s", time.Since(start))
+	}()
+
+	ok, err := s.matchesSHA256(srcPath, expected)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if !ok {
+		return valMismatch, nil
+	}
+
+	return s.copyFile(srcPath, destPath, opts)
+}
+
+type treeHashEntry struct {
+	rel    string
+	mode   os.FileMode
+	digest []byte // Content of a file, or target of a symbolic link.
+}
+
+// treeHash returns a hex digest of the tree at dest as a JSON string.
+// The digest covers the relative path, the mode, and the content of every
+// entry, and doesn't depend on the walk order.
+func (s *session) treeHash(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("treeHash took %s", time.Since(start))
+	}()
+
+	tree, exists := s.walkRoot(destPath)
+	if !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	mux := &sync.Mutex{}
+	var entries []*treeHashEntry
+
+	// The walk visits entries all at once, so cap the files open for hashing.
+	opens := make(chan struct{}, treeHashConcurrency)
+
+	err := concurrentWalk(destPath, tree, func(path string, fi os.FileInfo) error {
+		e := &treeHashEntry{
+			rel:  strings.TrimPrefix(strings.TrimPrefix(path, destPath), "/"),
+			mode: fi.Mode() & (os.ModeType | os.ModePerm | specialBits),
+		}
+
+		switch {
+		case fi.Mode().IsRegular():
+			opens <- struct{}{}
+			d, err := fileSHA256(path, s.cfg.copyBufferSize)
+			<-opens
+			if err != nil {
+				return err
+			}
+			e.digest = d
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			d := sha256.Sum256([]byte(target))
+			e.digest = d[:]
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return valFalse, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+
+	h := sha256.New()
+	for _, e := range entries {
+		// NUL never appears in a path, so entries can't be confused.
+		fmt.Fprintf(h, "%s\x00%d\x00%x\n", e.rel, uint32(e.mode), e.digest)
+	}
+
+	j, err := json.Marshal(hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
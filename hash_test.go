@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_VerifySHA256(t *testing.T) {
+	t.Run("match", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			sha256Hex(testLongContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("mismatch", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			sha256Hex(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("invalid digest", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "xyz"}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			sha256Hex(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_VerifySHA256_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			sha256Hex("")))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_TreeHash(t *testing.T) {
+	treeHash := func(p *testpack, dir string) string {
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "tree_hash": true}`, p.fs.path(dir)))
+		p.assert.NoError(err)
+		return res
+	}
+
+	setup := func(p *testpack, dir string) {
+		p.fs.dir(dir).create()
+		p.fs.dir(dir + "/" + testDir1).create()
+		p.fs.file(dir + "/" + testFile1).write(testContent1)
+		p.fs.file(dir + "/" + testDir1 + "/" + testFile2).write(testContent2)
+	}
+
+	t.Run("identical trees", run(func(p *testpack) {
+		setup(p, testDir1)
+		setup(p, testDir2)
+
+		p.assert.Equal(treeHash(p, testDir1), treeHash(p, testDir2))
+	}))
+
+	t.Run("content differs", run(func(p *testpack) {
+		setup(p, testDir1)
+		setup(p, testDir2)
+		p.fs.file(testDir2 + "/" + testFile1).write(testContent2)
+
+		p.assert.NotEqual(treeHash(p, testDir1), treeHash(p, testDir2))
+	}))
+
+	t.Run("mode differs", run(func(p *testpack) {
+		setup(p, testDir1)
+		setup(p, testDir2)
+		p.fs.file(testDir2 + "/" + testFile1).chmod(testFilePerm1)
+
+		p.assert.NotEqual(treeHash(p, testDir1), treeHash(p, testDir2))
+	}))
+
+	t.Run("more files than concurrency", run(func(p *testpack) {
+		for _, dir := range []string{testDir1, testDir2} {
+			p.fs.dir(dir).create()
+			for i := 0; i < treeHashConcurrency*4; i++ {
+				p.fs.file(fmt.Sprintf("%s/%d.txt", dir, i)).write(testContent1)
+			}
+		}
+
+		p.assert.Equal(treeHash(p, testDir1), treeHash(p, testDir2))
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "tree_hash": true}`, p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_TreeHash_Speculate(t *testing.T) {
+	t.Run("speculative new file skipped", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		before, err := p.sess.addTask(taskf(`{"dest": "%s", "tree_hash": true}`, p.fs.path(testDir1)))
+		p.assert.NoError(err)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+
+		after, err := p.sess.addTask(taskf(`{"dest": "%s", "tree_hash": true}`, p.fs.path(testDir1)))
+		p.assert.NoError(err)
+		p.assert.Equal(before, after)
+	}))
+}
+
+func Test_ExpectSrcSHA256(t *testing.T) {
+	t.Run("match", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "expect_src_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2),
+			sha256Hex(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("mismatch", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "expect_src_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2),
+			sha256Hex(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("mismatch", res)
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("invalid digest", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "expect_src_sha256": "xyz"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+const keepAlivePeriod = 30 * time.Second
+
+// isHeartbeat reports whether the message is {"heartbeat": true}, which only
+// keeps the connection alive and never reaches the session.
+func isHeartbeat(msg []byte) bool {
+	// Avoid decoding ordinary tasks twice.
+	if !bytes.Contains(msg, []byte(`"heartbeat"`)) {
+		return false
+	}
+
+	var h struct {
+		Heartbeat bool `json:"heartbeat"`
+	}
+	if err := json.Unmarshal(msg, &h); err != nil {
+		return false
+	}
+
+	return h.Heartbeat
+}
+
+// setKeepAlive enables TCP keepalive so that dead peers are detected.
+// Other connections are left as is.
+func setKeepAlive(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	_ = tcp.SetKeepAlive(true)
+	_ = tcp.SetKeepAlivePeriod(keepAlivePeriod)
+}
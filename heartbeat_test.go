@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Heartbeat(t *testing.T) {
+	t.Run("detect", run(func(p *testpack) {
+		p.assert.True(isHeartbeat([]byte(`{"heartbeat": true}`)))
+		p.assert.False(isHeartbeat([]byte(`{"heartbeat": false}`)))
+		p.assert.False(isHeartbeat([]byte(`{"dest": "heartbeat", "existence": true}`)))
+	}))
+
+	t.Run("reply", run(func(p *testpack) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		go func() {
+			defer server.Close()
+			handleConnection(context.Background(), server, defaultConfig())
+		}()
+
+		client.Write([]byte(`{"heartbeat": true}` + "\n"))
+		res, err := bufio.NewReader(client).ReadString('\n')
+
+		p.assert.NoError(err)
+		p.assert.Equal("true\n", res)
+	}))
+
+	t.Run("idle timeout", run(func(p *testpack) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		cfg := defaultConfig()
+		cfg.idleTimeout = 50 * time.Millisecond
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			defer server.Close()
+			handleConnection(context.Background(), server, cfg)
+		}()
+
+		// Heartbeats keep the connection alive beyond the timeout.
+		reader := bufio.NewReader(client)
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			client.Write([]byte(`{"heartbeat": true}` + "\n"))
+			res, err := reader.ReadString('\n')
+			p.assert.NoError(err)
+			p.assert.Equal("true\n", res)
+		}
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			p.assert.Fail("connection not closed after idle timeout")
+		}
+	}))
+
+	t.Run("idle timeout reloaded", run(func(p *testpack) {
+		defer liveConfig.Store(nil)
+
+		server, client := net.Pipe()
+		defer client.Close()
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			defer server.Close()
+			handleConnection(context.Background(), server, defaultConfig())
+		}()
+
+		cfg := defaultConfig()
+		cfg.idleTimeout = 50 * time.Millisecond
+		liveConfig.Store(cfg)
+
+		// The connection picks up the reloaded config with the next message.
+		client.Write([]byte(`{"heartbeat": true}` + "\n"))
+		res, err := bufio.NewReader(client).ReadString('\n')
+		p.assert.NoError(err)
+		p.assert.Equal("true\n", res)
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			p.assert.Fail("connection not closed after idle timeout")
+		}
+	}))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// version is overridden at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// capabilities lists the optional protocol features this daemon supports,
+// so that clients can adapt without sniffing the version.
+var capabilities = []string{
+	"batch",
+	"stats",
+	"checksum",
+	"move",
+	"mktemp",
+	"statfs",
+	"atomic",
+	"heartbeat",
+	"handle",
+	"binary",
+	"tx",
+	"cas",
+	"busy",
+	"listdir_stream",
+}
+
+type helloResult struct {
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+	Binary       bool     `json:"binary"`
+}
+
+// hello reports what this daemon supports. A non-nil binary switches the
+// response mode of the session from the next response on, so that the
+// reply to hello itself is always readable by the client asking.
+func (s *session) hello(binary *bool) (string, error) {
+	if binary != nil {
+		s.binary = *binary
+	}
+
+	j, err := json.Marshal(&helloResult{
+		Version:      version,
+		Capabilities: capabilities,
+		Binary:       s.binary,
+	})
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
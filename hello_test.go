@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+)
+
+func Test_Hello(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"hello": true}`))
+
+		p.assert.NoError(err)
+
+		var hello helloResult
+		p.assert.NoError(json.Unmarshal([]byte(res), &hello))
+		p.assert.Equal(version, hello.Version)
+		p.assert.Contains(hello.Capabilities, "batch")
+		p.assert.Contains(hello.Capabilities, "listdir_stream")
+	}))
+}
+
+func Test_Hello_Binary(t *testing.T) {
+	t.Run("switch", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"hello": true, "binary": true}`))
+
+		p.assert.NoError(err)
+		p.assert.True(p.sess.binary)
+
+		var hello helloResult
+		p.assert.NoError(json.Unmarshal([]byte(res), &hello))
+		p.assert.True(hello.Binary)
+
+		_, err = p.sess.addTask([]byte(`{"hello": true}`))
+		p.assert.NoError(err)
+		p.assert.True(p.sess.binary)
+
+		_, err = p.sess.addTask([]byte(`{"hello": true, "binary": false}`))
+		p.assert.NoError(err)
+		p.assert.False(p.sess.binary)
+	}))
+
+	t.Run("over connection", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		server, client := net.Pipe()
+		defer client.Close()
+
+		go func() {
+			defer server.Close()
+			handleConnection(context.Background(), server, defaultConfig())
+		}()
+
+		reader := bufio.NewReader(client)
+
+		// The reply to hello is still a line.
+		client.Write([]byte(`{"hello": true, "binary": true}` + "\n"))
+		line, err := reader.ReadString('\n')
+		p.assert.NoError(err)
+
+		var hello helloResult
+		p.assert.NoError(json.Unmarshal([]byte(line), &hello))
+		p.assert.True(hello.Binary)
+
+		readByte := func(req []byte) byte {
+			client.Write(append(req, '\n'))
+			b, err := reader.ReadByte()
+			p.assert.NoError(err)
+			return b
+		}
+
+		p.assert.Equal(byte(binTrue), readByte(taskf(`{"dest": "%s", "existence": true}`, p.fs.path(testFile1))))
+		p.assert.Equal(byte(binFalse), readByte(taskf(`{"dest": "%s", "existence": true}`, p.fs.path(testFile2))))
+		p.assert.Equal(byte(binInvalid), readByte([]byte(`{"unknown": true}`)))
+		p.assert.Equal(byte(binTrue), readByte([]byte(`{"heartbeat": true}`)))
+
+		p.assert.Equal(byte(frameTag), readByte(taskf(`{"dest": "%s", "read_head": 100}`, p.fs.path(testFile1))))
+		size := make([]byte, 4)
+		_, err = io.ReadFull(reader, size)
+		p.assert.NoError(err)
+		payload := make([]byte, binary.BigEndian.Uint32(size))
+		_, err = io.ReadFull(reader, payload)
+		p.assert.NoError(err)
+		p.assert.Contains(string(payload), b64String(testContent1))
+	}))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type inodeResult struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+// inode returns the device and inode numbers of the file, which identify
+// hard links to the same file. A speculative new file has none yet.
+func (s *session) inode(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("inode took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valInvalid, nil
+	}
+
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	dev, err := statDevice(fi)
+	if err != nil {
+		return valFalse, err
+	}
+
+	ino, err := statInode(fi)
+	if err != nil {
+		return valFalse, err
+	}
+
+	j, err := json.Marshal(&inodeResult{Dev: dev, Ino: ino})
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
+
+// nlink returns the number of hard links to the file, so that content still
+// referenced elsewhere isn't cleaned up. A speculative new file has none yet.
+func (s *session) nlink(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("nlink took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valInvalid, nil
+	}
+
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	n, err := statNlink(fi)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return strconv.FormatUint(n, 10), nil
+}
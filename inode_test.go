@@ -0,0 +1,109 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func Test_Inode(t *testing.T) {
+	inode := func(p *testpack, name string) inodeResult {
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "inode": true}`, p.fs.path(name)))
+		p.assert.NoError(err)
+
+		var r inodeResult
+		p.assert.NoError(json.Unmarshal([]byte(res), &r))
+		return r
+	}
+
+	t.Run("hard links", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.assert.NoError(os.Link(p.fs.path(testFile1), p.fs.path(testFile2)))
+
+		p.assert.Equal(inode(p, testFile1), inode(p, testFile2))
+	}))
+
+	t.Run("distinct files", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent1)
+
+		i1 := inode(p, testFile1)
+		i2 := inode(p, testFile2)
+		p.assert.Equal(i1.Dev, i2.Dev)
+		p.assert.NotEqual(i1.Ino, i2.Ino)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "inode": true}`, p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Inode_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "inode": true}`, p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("speculative existing file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "inode": true}`, p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.NotEqual("null", res)
+	}))
+}
+
+func Test_Nlink(t *testing.T) {
+	nlink := func(p *testpack, name string) (string, error) {
+		return p.sess.addTask(taskf(`{"dest": "%s", "nlink": true}`, p.fs.path(name)))
+	}
+
+	t.Run("single", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := nlink(p, testFile1)
+
+		p.assert.NoError(err)
+		p.assert.Equal("1", res)
+	}))
+
+	t.Run("hard links", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.assert.NoError(os.Link(p.fs.path(testFile1), p.fs.path(testFile2)))
+
+		res, err := nlink(p, testFile2)
+
+		p.assert.NoError(err)
+		p.assert.Equal("2", res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := nlink(p, testFile1)
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := nlink(p, testFile1)
+
+		p.assert.NoError(err)
+		p.assert.Equal("null", res)
+	}))
+}
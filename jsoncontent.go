@@ -0,0 +1,19 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// marshalJSONContent validates the raw value of "content_json" and returns
+// it compacted with a trailing newline, as written to the file.
+func marshalJSONContent(raw json.RawMessage) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, raw); err != nil {
+		return nil, fmt.Errorf("malformed content_json: %w", err)
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
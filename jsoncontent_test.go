@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_ContentJSON(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_json": {"name": "app", "ports": [80, 443], "debug": false}}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(`{"name":"app","ports":[80,443],"debug":false}`+"\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("overwrite", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_json": [1, 2.50, "x"]}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(`[1,2.50,"x"]`+"\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("malformed", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_json": {"name": }}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("malformed value", run(func(p *testpack) {
+		_, err := marshalJSONContent([]byte(`{"name": }`))
+		p.assert.Error(err)
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_json": {"a": 1}}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(`{"a":1}`+"\n", p.fs.file(testFile1).read())
+	}))
+}
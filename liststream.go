@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultListChunkSize is the number of entries per line of listdir_stream.
+const defaultListChunkSize = 1000
+
+// listDirStream sends the entries of the directory as JSON arrays of at most
+// chunkSize names, one line each, without holding the whole listing. The
+// returned response is the empty array that ends the stream, or false if the
+// listing failed halfway.
+func (s *session) listDirStream(dirPath string, chunkSize int) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("listDirStream took %s", time.Since(start))
+	}()
+
+	if s.emit == nil {
+		return valFalse, fmt.Errorf("streaming is unavailable here")
+	}
+
+	if chunkSize <= 0 {
+		return valFalse, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+
+	d := s.findSpeculativeDir(dirPath)
+
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return valFalse, err
+	}
+	defer f.Close()
+
+	for {
+		names, err := f.Readdirnames(chunkSize)
+		if err != nil && err != io.EOF {
+			return valFalse, err
+		}
+
+		chunk := make([]string, 0, len(names))
+		for _, n := range names {
+			if d == nil || d.logicallyExists(n) {
+				chunk = append(chunk, n)
+			}
+		}
+
+		// An empty line would be taken for the end of the stream.
+		if len(chunk) != 0 {
+			j, err := json.Marshal(chunk)
+			if err != nil {
+				return valFalse, err
+			}
+
+			if err := s.emit(j); err != nil {
+				return valFalse, err
+			}
+		}
+
+		if err == io.EOF || len(names) == 0 {
+			return "[]", nil
+		}
+	}
+}
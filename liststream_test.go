@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func Test_ListDirStream(t *testing.T) {
+	capture := func(p *testpack) *[][]string {
+		chunks := &[][]string{}
+		p.sess.emit = func(line []byte) error {
+			var chunk []string
+			p.assert.NoError(json.Unmarshal(line, &chunk))
+			*chunks = append(*chunks, chunk)
+			return nil
+		}
+		return chunks
+	}
+
+	t.Run("chunks", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		for i := 0; i < 5; i++ {
+			p.fs.file(fmt.Sprintf("%s/%d.txt", testDir1, i)).write(testContent1)
+		}
+		chunks := capture(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_stream": true, "chunk_size": 2}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[]", res)
+		p.assert.Len(*chunks, 3)
+
+		names := []string{}
+		for _, c := range *chunks {
+			p.assert.LessOrEqual(len(c), 2)
+			names = append(names, c...)
+		}
+		p.assert.ElementsMatch([]string{"0.txt", "1.txt", "2.txt", "3.txt", "4.txt"}, names)
+	}))
+
+	t.Run("empty", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		chunks := capture(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_stream": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[]", res)
+		p.assert.Empty(*chunks)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		capture(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_stream": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("unavailable", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_stream": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("in batch", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		chunks := capture(p)
+
+		res, err := p.sess.addTask(taskf(
+			`[{"dest": "%s", "listdir_stream": true}]`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Empty(*chunks)
+
+		batch := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), batch))
+		p.assert.Equal(1, batch.Failed)
+		p.assert.NotNil(p.sess.emit)
+	}))
+
+	t.Run("over connection", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1File2).write(testContent2)
+
+		server, client := net.Pipe()
+		defer client.Close()
+
+		go func() {
+			defer server.Close()
+			handleConnection(context.Background(), server, defaultConfig())
+		}()
+
+		client.Write(taskf(
+			`{"dest": "%s", "listdir_stream": true, "chunk_size": 1}`+"\n",
+			p.fs.path(testDir1)))
+
+		reader := bufio.NewReader(client)
+		names := []string{}
+		for {
+			line, err := reader.ReadString('\n')
+			p.assert.NoError(err)
+			if line == "[]\n" {
+				break
+			}
+
+			var chunk []string
+			p.assert.NoError(json.Unmarshal([]byte(line), &chunk))
+			names = append(names, chunk...)
+		}
+
+		p.assert.ElementsMatch([]string{testFile1, testFile2}, names)
+	}))
+}
+
+func Test_ListDirStream_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+
+		lines := []string{}
+		p.sess.emit = func(line []byte) error {
+			lines = append(lines, string(line))
+			return nil
+		}
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_stream": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[]", res)
+		p.assert.Equal([]string{`["test.txt"]`}, lines)
+	}))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// loggedRequests counts requests considered for sampling across sessions.
+var loggedRequests atomic.Uint64
+
+// sampleRequestLog reports whether the request and the response should be
+// logged. Errors are logged regardless.
+func (c *config) sampleRequestLog() bool {
+	if c.quiet {
+		return false
+	}
+
+	if c.logSample <= 1 {
+		return true
+	}
+
+	// Log the first one and every logSample-th one after that.
+	return (loggedRequests.Add(1)-1)%uint64(c.logSample) == 0
+}
+
+// truncateLog shortens the content to at most max bytes without splitting a
+// multibyte character. Zero or less means unlimited.
+func truncateLog(content []byte, max int) string {
+	if max <= 0 || len(content) <= max {
+		return string(content)
+	}
+
+	i := max
+	for 0 < i && !utf8.RuneStart(content[i]) {
+		i--
+	}
+
+	return fmt.Sprintf("%s...(%d bytes truncated)", content[:i], len(content)-i)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_SampleRequestLog(t *testing.T) {
+	t.Run("every request by default", run(func(p *testpack) {
+		cfg := defaultConfig()
+
+		for i := 0; i < 3; i++ {
+			p.assert.True(cfg.sampleRequestLog())
+		}
+	}))
+
+	t.Run("sampled", run(func(p *testpack) {
+		cfg := defaultConfig()
+		cfg.logSample = 3
+		loggedRequests.Store(0)
+
+		var logged int
+		for i := 0; i < 9; i++ {
+			if cfg.sampleRequestLog() {
+				logged++
+			}
+		}
+
+		p.assert.Equal(3, logged)
+	}))
+
+	t.Run("quiet", run(func(p *testpack) {
+		cfg := defaultConfig()
+		cfg.quiet = true
+
+		p.assert.False(cfg.sampleRequestLog())
+	}))
+}
+
+func Test_TruncateLog(t *testing.T) {
+	t.Run("short", run(func(p *testpack) {
+		p.assert.Equal(testContent1, truncateLog([]byte(testContent1), 1000))
+	}))
+
+	t.Run("unlimited", run(func(p *testpack) {
+		p.assert.Equal(testContent1, truncateLog([]byte(testContent1), 0))
+	}))
+
+	t.Run("truncated", run(func(p *testpack) {
+		p.assert.Equal("test...(7 bytes truncated)", truncateLog([]byte(testContent1), 4))
+	}))
+
+	t.Run("multibyte", run(func(p *testpack) {
+		// "あ" is 3 bytes in UTF-8.
+		res := truncateLog([]byte("aあい"), 2)
+
+		p.assert.Equal("a...(6 bytes truncated)", res)
+		p.assert.True(utf8.ValidString(res))
+	}))
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -22,8 +25,9 @@ func main() {
 	})
 
 	app := &cli.App{
-		Name:  "parallelefs",
-		Usage: "This program writes files in parallel to speed up EFS.",
+		Name:    "parallelefs",
+		Usage:   "This program writes files in parallel to speed up EFS.",
+		Version: version,
 		Flags: []cli.Flag{
 			&cli.PathFlag{
 				Name:     "socket",
@@ -39,7 +43,132 @@ func main() {
 			&cli.BoolFlag{
 				Name:     "debug",
 				Required: false,
-				Usage:    "Enbale debug log",
+				Usage:    "Enbale debug log and debugging tasks such as dump_tree",
+			},
+			&cli.IntFlag{
+				Name:     "max-speculations",
+				Required: false,
+				Value:    0,
+				Usage:    "Maximum number of live speculative files per session (0 means unlimited)",
+			},
+			&cli.PathFlag{
+				Name:     "root",
+				Required: false,
+				Usage:    "Base directory against which relative paths are resolved",
+			},
+			&cli.BoolFlag{
+				Name:     "verbose",
+				Required: false,
+				Usage:    "Return descriptive responses such as hints for invalid tasks",
+			},
+			&cli.BoolFlag{
+				Name:     "lenient-json",
+				Required: false,
+				Usage:    "Ignore unknown task fields for compatibility with older clients",
+			},
+			&cli.BoolFlag{
+				Name:     "shared-speculation",
+				Required: false,
+				Usage:    "Let a connection claim files speculated by another connection",
+			},
+			&cli.StringSliceFlag{
+				Name:     "allow-prefix",
+				Required: false,
+				Usage:    "Directory under which paths are allowed (repeatable; all paths are allowed if omitted)",
+			},
+			&cli.StringFlag{
+				Name:     "umask",
+				Required: false,
+				Usage: "Octal umask of the process (e.g. 022) applied to created files and directories. " +
+					"Tasks with an explicit perm are still changed to exactly that mode",
+			},
+			&cli.DurationFlag{
+				Name:     "idle-timeout",
+				Required: false,
+				Value:    0,
+				Usage:    "Close connections idle for this long (0 means never). Heartbeats reset the timer",
+			},
+			&cli.Int64Flag{
+				Name:     "max-write-bytes",
+				Required: false,
+				Value:    0,
+				Usage:    "Maximum size of a file written by a single task (0 means unlimited)",
+			},
+			&cli.IntFlag{
+				Name:     "log-sample",
+				Required: false,
+				Value:    1,
+				Usage:    "Log only 1 in N requests and responses. Errors are always logged",
+			},
+			&cli.IntFlag{
+				Name:     "max-log-content",
+				Required: false,
+				Value:    1000,
+				Usage:    "Maximum bytes of a request or a response to log (0 means unlimited)",
+			},
+			&cli.BoolFlag{
+				Name:     "quiet",
+				Required: false,
+				Usage:    "Don't log requests and responses. Errors are still logged",
+			},
+			&cli.StringFlag{
+				Name:     "profile",
+				Required: false,
+				Usage: "Tuning defaults for the filesystem (" + profileNames() + "). " +
+					"Individual flags override the profile",
+			},
+			&cli.IntFlag{
+				Name:     "copy-buffer-size",
+				Required: false,
+				Usage:    "Size in bytes of the buffer used to copy files",
+			},
+			&cli.IntFlag{
+				Name:     "speculate-concurrency",
+				Required: false,
+				Usage:    "Maximum number of concurrent speculative opens per session (0 means unlimited)",
+			},
+			&cli.BoolFlag{
+				Name:     "no-speculation",
+				Required: false,
+				Usage:    "Accept speculate tasks but do nothing, opening every file on demand instead",
+			},
+			&cli.BoolFlag{
+				Name:     "make-parents",
+				Required: false,
+				Usage:    "Create missing parent directories on create and copy unless a task sets make_parents",
+			},
+			&cli.PathFlag{
+				Name:     "config",
+				Required: false,
+				Usage:    "JSON file of settings overriding the flags, read again on SIGHUP",
+			},
+			&cli.Int64Flag{
+				Name:     "max-in-flight",
+				Required: false,
+				Value:    0,
+				Usage:    "Respond busy while this many tasks and speculative opens are in flight (0 means unlimited)",
+			},
+			&cli.BoolFlag{
+				Name:     "admin-enabled",
+				Required: false,
+				Usage:    "Allow admin tasks such as set_root and get_config from any connection",
+			},
+			&cli.Int64Flag{
+				Name:     "max-content-memory",
+				Required: false,
+				Value:    0,
+				Usage:    "Maximum total bytes of task inputs processed at once; more tasks wait (0 means unlimited)",
+			},
+			&cli.IntFlag{
+				Name:     "dir-cache-size",
+				Required: false,
+				Value:    0,
+				Usage:    "Remember this many directory existence checks per session, trading freshness for fewer stats (0 disables)",
+			},
+			&cli.IntFlag{
+				Name:     "clean-concurrency",
+				Required: false,
+				Usage:    "Maximum number of goroutines per directory disposing unused speculations (0 means unlimited)",
 			},
 		},
 		Action: func(c *cli.Context) error {
@@ -48,11 +177,89 @@ func main() {
 				return err
 			}
 
+			if c.IsSet("umask") {
+				mask, err := strconv.ParseUint(c.String("umask"), 8, 32)
+				if err != nil {
+					return fmt.Errorf("invalid umask: %w", err)
+				}
+
+				if err := setUmask(int(mask)); err != nil {
+					return err
+				}
+			}
+
+			cfg := defaultConfig()
 			if c.Bool("debug") {
-				log.SetLevel(log.DebugLevel)
+				cfg.logLevel = log.DebugLevel
+			}
+			cfg.maxSpeculations = c.Int("max-speculations")
+			cfg.verbose = c.Bool("verbose")
+			cfg.lenientJSON = c.Bool("lenient-json")
+			cfg.sharedSpeculation = c.Bool("shared-speculation")
+			cfg.idleTimeout = c.Duration("idle-timeout")
+			cfg.maxWriteBytes = c.Int64("max-write-bytes")
+			cfg.debug = c.Bool("debug")
+			cfg.logSample = c.Int("log-sample")
+			cfg.quiet = c.Bool("quiet")
+			cfg.maxLogContent = c.Int("max-log-content")
+			cfg.noSpeculation = c.Bool("no-speculation")
+			cfg.makeParents = c.Bool("make-parents")
+			cfg.adminEnabled = c.Bool("admin-enabled")
+			cfg.maxInFlight = c.Int64("max-in-flight")
+			cfg.dirCacheSize = c.Int("dir-cache-size")
+			cfg.maxContentMemory = c.Int64("max-content-memory")
+
+			if c.IsSet("profile") {
+				if err := cfg.applyProfile(c.String("profile")); err != nil {
+					return err
+				}
+			}
+			if c.IsSet("copy-buffer-size") {
+				if c.Int("copy-buffer-size") <= 0 {
+					return fmt.Errorf("copy-buffer-size must be positive")
+				}
+				cfg.copyBufferSize = c.Int("copy-buffer-size")
+			}
+			if c.IsSet("speculate-concurrency") {
+				cfg.speculateConcurrency = c.Int("speculate-concurrency")
+			}
+			if c.IsSet("clean-concurrency") {
+				cfg.cleanConcurrency = c.Int("clean-concurrency")
+			}
+
+			for _, prefix := range c.StringSlice("allow-prefix") {
+				abs, err := filepath.Abs(prefix)
+				if err != nil {
+					return err
+				}
+				cfg.allowPrefixes = append(cfg.allowPrefixes, abs)
+			}
+
+			if c.Path("root") != "" {
+				root, err := filepath.Abs(c.Path("root"))
+				if err != nil {
+					return err
+				}
+				cfg.root = root
+			}
+
+			// SIGHUP starts over from the flags, reading the file again.
+			flags := *cfg
+			configPath := c.Path("config")
+			if configPath != "" {
+				if err := cfg.applyFile(configPath); err != nil {
+					return err
+				}
+			}
+			log.SetLevel(cfg.logLevel)
+
+			if err := removeStaleSocket(socket); err != nil {
+				return err
 			}
 
-			listen(socket)
+			listen(socket, cfg, func() {
+				reload(&flags, configPath)
+			})
 
 			return nil
 		},
@@ -71,10 +278,25 @@ func main() {
 	}
 }
 
-func listen(socket string) {
-	// Ignore error
-	_ = os.Remove(socket)
+// removeStaleSocket removes the socket left by a previous run. Anything else
+// at the path is kept, since --socket may point at a user's file by mistake.
+func removeStaleSocket(socket string) error {
+	fi, err := os.Lstat(socket)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("refusing to replace what isn't a socket: %s", socket)
+	}
+
+	return os.Remove(socket)
+}
 
+func listen(socket string, cfg *config, reload func()) {
 	listener, err := net.Listen("unix", socket)
 	if err != nil {
 		log.Panic(err)
@@ -83,6 +305,8 @@ func listen(socket string) {
 	log.Debugf("started listening")
 
 	ctx, cancel := context.WithCancel(context.Background())
+	liveConfig.Store(cfg)
+	contentBudget = newMemoryBudget(cfg.maxContentMemory)
 
 	go func() {
 		for {
@@ -92,17 +316,30 @@ func listen(socket string) {
 				return
 			}
 
+			setKeepAlive(conn)
+
+			// Admin tasks may have replaced the config since.
+			cfg := liveConfig.Load()
 			go func() {
 				defer conn.Close()
-				handleConnection(ctx, conn)
+				handleConnection(ctx, conn, cfg)
 			}()
 		}
 	}()
 
 	// Wait until interrupted
-	<-interruptionNotification()
-	log.Debugf("quitting")
-	cancel()
+	interrupted := interruptionNotification()
+	reloading := reloadNotification()
+	for {
+		select {
+		case <-interrupted:
+			log.Debugf("quitting")
+			cancel()
+			return
+		case <-reloading:
+			reload()
+		}
+	}
 }
 
 func interruptionNotification() <-chan os.Signal {
@@ -111,20 +348,73 @@ func interruptionNotification() <-chan os.Signal {
 	return sigCh
 }
 
-func handleConnection(ctx context.Context, conn io.ReadWriter) {
-	sess := newSession()
+func reloadNotification() <-chan os.Signal {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	return sigCh
+}
+
+func handleConnection(ctx context.Context, conn io.ReadWriter, cfg *config) {
+	sess := newSession(cfg)
 	defer sess.finalize()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	sess.ctx = ctx
 
 	log.Debugf("started new session")
 
 	recvLine := connReader(conn)
+	writeLine := connWriter(conn)
+	writeFrame := connFrameWriter(conn)
+
+	// hello may switch the mode anytime.
+	sendLine := func(res []byte) error {
+		if sess.binary {
+			return writeFrame(res)
+		}
+		return writeLine(res)
+	}
+	sess.emit = sendLine
+
+	// A nil channel never fires, which disables the idle timeout.
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	resetIdle := func(timeout time.Duration) {
+		if idleTimer != nil {
+			// Drain a tick that fired concurrently so it isn't seen after Reset.
+			if !idleTimer.Stop() {
+				select {
+				case <-idleTimer.C:
+				default:
+				}
+			}
+		}
+
+		if timeout <= 0 {
+			return
+		}
+
+		if idleTimer == nil {
+			idleTimer = time.NewTimer(timeout)
+			idle = idleTimer.C
+			return
+		}
+		idleTimer.Reset(timeout)
+	}
+	defer func() {
+		if idleTimer != nil {
+			idleTimer.Stop()
+		}
+	}()
+	resetIdle(cfg.idleTimeout)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-idle:
+			log.Debugf("closing idle session")
+			cancel()
 		case msg, ok := <-recvLine:
 			if !ok {
 				cancel()
@@ -133,24 +423,45 @@ func handleConnection(ctx context.Context, conn io.ReadWriter) {
 
 			log.Debugf("received: %d bytes", len(msg))
 
+			// Settings reloaded since the connection was accepted apply from
+			// this message on.
+			cfg := sess.currentConfig()
+			resetIdle(cfg.idleTimeout)
+
+			if isHeartbeat(msg) {
+				sendLine([]byte(valTrue))
+				continue
+			}
+
 			// Empty request means the end of this session.
 			if len(msg) == 0 {
 				sess.finalize()
-				conn.Write([]byte("true\n"))
+				sendLine([]byte(valTrue))
 				cancel()
 				continue
 			}
 
-			log.Infof("req: %s", string(msg))
+			sampled := cfg.sampleRequestLog()
+			if sampled {
+				log.Infof("req: %s", truncateLog(msg, cfg.maxLogContent))
+			}
+			// Reply to hello in the mode the client asked it in.
+			sendRes := writeLine
+			if sess.binary {
+				sendRes = writeFrame
+			}
+
 			res, err := sess.addTask(msg)
 			if err != nil {
 				log.Error(err)
 			}
 
-			resbs := []byte(res + "\n")
-			conn.Write(resbs)
-			log.Debugf("sent: %d bytes", len(resbs))
-			log.Infof("res: %s", string(resbs))
+			resbs := []byte(res)
+			sendRes(resbs)
+			log.Debugf("sent: %d bytes", len(resbs)+1)
+			if sampled {
+				log.Infof("res: %s", truncateLog(resbs, cfg.maxLogContent))
+			}
 		}
 	}
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_RemoveStaleSocket(t *testing.T) {
+	t.Run("stale socket", run(func(p *testpack) {
+		l, err := net.Listen("unix", p.fs.path(testFile1))
+		p.assert.NoError(err)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+
+		p.assert.NoError(removeStaleSocket(p.fs.path(testFile1)))
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		p.assert.NoError(removeStaleSocket(p.fs.path(testFile1)))
+	}))
+
+	t.Run("regular file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.assert.Error(removeStaleSocket(p.fs.path(testFile1)))
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		p.assert.Error(removeStaleSocket(p.fs.path(testDir1)))
+		p.assert.True(p.fs.dir(testDir1).exists())
+	}))
+}
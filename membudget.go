@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// memoryBudget bounds the bytes of task inputs, such as "content_b64",
+// being processed at once daemon-wide.
+type memoryBudget struct {
+	sem  *semaphore.Weighted
+	size int64
+}
+
+// contentBudget is set from --max-content-memory when listening.
+// Nil means unbounded.
+var contentBudget *memoryBudget
+
+// newMemoryBudget returns a budget of size bytes, or nil if size isn't
+// positive.
+func newMemoryBudget(size int64) *memoryBudget {
+	if size <= 0 {
+		return nil
+	}
+
+	return &memoryBudget{sem: semaphore.NewWeighted(size), size: size}
+}
+
+// reserve blocks until n bytes are available and returns the function giving
+// them back. An input larger than the whole budget waits for all of it, so
+// that it runs alone rather than never.
+func (b *memoryBudget) reserve(ctx context.Context, n int64) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+
+	if n > b.size {
+		n = b.size
+	}
+
+	start := time.Now()
+	err := b.sem.Acquire(ctx, n)
+	metrics.contentMemoryWait.Add(int64(time.Since(start)))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { b.sem.Release(n) }, nil
+}
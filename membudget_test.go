@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_MemoryBudget(t *testing.T) {
+	t.Run("unbounded", run(func(p *testpack) {
+		release, err := newMemoryBudget(0).reserve(context.Background(), 1<<40)
+
+		p.assert.NoError(err)
+		release()
+	}))
+
+	t.Run("waits for release", run(func(p *testpack) {
+		b := newMemoryBudget(10)
+		release, err := b.reserve(context.Background(), 8)
+		p.assert.NoError(err)
+
+		reserved := make(chan struct{})
+		go func() {
+			r, err := b.reserve(context.Background(), 5)
+			p.assert.NoError(err)
+			r()
+			close(reserved)
+		}()
+
+		select {
+		case <-reserved:
+			p.assert.Fail("reserved beyond the budget")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		release()
+		<-reserved
+	}))
+
+	t.Run("larger than budget", run(func(p *testpack) {
+		release, err := newMemoryBudget(10).reserve(context.Background(), 100)
+
+		p.assert.NoError(err)
+		release()
+	}))
+
+	t.Run("cancelled", run(func(p *testpack) {
+		b := newMemoryBudget(10)
+		release, err := b.reserve(context.Background(), 10)
+		p.assert.NoError(err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = b.reserve(ctx, 1)
+		p.assert.ErrorIs(err, context.DeadlineExceeded)
+	}))
+}
+
+func Test_MaxContentMemory(t *testing.T) {
+	t.Run("write waits", run(func(p *testpack) {
+		contentBudget = newMemoryBudget(16)
+		defer func() {
+			contentBudget = nil
+		}()
+		before := statsOf(p)
+
+		release, err := contentBudget.reserve(context.Background(), 16)
+		p.assert.NoError(err)
+		time.AfterFunc(20*time.Millisecond, release)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+		p.assert.Less(before.ContentMemoryWaitMS, statsOf(p).ContentMemoryWaitMS)
+	}))
+}
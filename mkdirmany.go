@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// mkdirManyConcurrency caps the concurrent mkdirs of a mkdir_many task.
+const mkdirManyConcurrency = 16
+
+type mkdirEntry struct {
+	Dest string  `json:"dest"`
+	Perm *uint32 `json:"perm"` // Overrides "perm" of the task.
+}
+
+// mkdirMany creates the directories like mkdir and reports the result of
+// each one in the same order: true if created, "exists" if already a
+// directory, or false with the error.
+//
+// Shallower paths are created first, so a parent listed along with its
+// children exists before them. Paths of the same depth are created
+// concurrently unless the speculative tree knows them.
+func (s *session) mkdirMany(entries []mkdirEntry, defaultPerm *os.FileMode, full bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("mkdirMany took %s", time.Since(start))
+	}()
+
+	paths := make([]string, len(entries))
+	perms := make([]*os.FileMode, len(entries))
+	results := make([]string, len(entries))
+	errs := make([]error, len(entries))
+
+	order := make([]int, 0, len(entries))
+	for i, e := range entries {
+		if paths[i], errs[i] = s.normalizePath(e.Dest); errs[i] != nil {
+			continue
+		}
+
+		perms[i] = defaultPerm
+		if e.Perm != nil {
+			p := taskMode(*e.Perm, full)
+			perms[i] = &p
+		}
+
+		order = append(order, i)
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return pathDepth(paths[order[a]]) < pathDepth(paths[order[b]])
+	})
+
+	for len(order) > 0 {
+		depth := pathDepth(paths[order[0]])
+		n := 1
+		for n < len(order) && pathDepth(paths[order[n]]) == depth {
+			n++
+		}
+
+		s.mkdirLevel(order[:n], paths, perms, errs)
+		order = order[n:]
+	}
+
+	res := &batchResult{
+		Results: make([]*batchEntry, 0, len(entries)),
+	}
+	for i := range entries {
+		results[i] = valTrue
+		err := errs[i]
+		if err != nil {
+			results[i] = valFalse
+			if errors.Is(err, os.ErrExist) && isDir(paths[i]) {
+				results[i] = valExists
+				err = nil
+			}
+		}
+
+		entry := &batchEntry{Result: results[i]}
+		if err != nil {
+			msg := err.Error()
+			entry.Error = &msg
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+		res.Results = append(res.Results, entry)
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
+
+// mkdirLevel creates the directories of the same depth at the indices.
+func (s *session) mkdirLevel(indices []int, paths []string, perms []*os.FileMode, errs []error) {
+	// The speculative tree isn't goroutine-safe; only touch disk concurrently.
+	eg := &errgroup.Group{}
+	eg.SetLimit(mkdirManyConcurrency)
+	for _, i := range indices {
+		i := i
+		if !s.outsideSpeculativeTree(paths[i]) {
+			if exists, found := s.speculativeExistence(paths[i]); found && exists {
+				errs[i] = fmt.Errorf("%w: %s", os.ErrExist, paths[i])
+				continue
+			}
+
+			errs[i] = s.mkdir(paths[i], perms[i])
+			continue
+		}
+
+		eg.Go(func() error {
+			errs[i] = mkdirOnDisk(paths[i], perms[i])
+			return nil
+		})
+	}
+	eg.Wait()
+}
+
+// outsideSpeculativeTree reports whether mkdir of the path would leave the
+// speculative tree as is, which is when an existent directory of the tree
+// is the deepest known ancestor.
+func (s *session) outsideSpeculativeTree(absDirPath string) bool {
+	if absDirPath == "/" {
+		return false
+	}
+
+	t := s.speculativeDirTree
+	for _, name := range strings.Split(absDirPath[1:], "/") {
+		d, ok := t.childDirs[name]
+		if !ok {
+			return !t.speculative
+		}
+		t = d
+	}
+
+	return false
+}
+
+func pathDepth(absPath string) int {
+	return strings.Count(absPath, "/")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func mkdirManyResults(p *testpack, res string) []string {
+	r := &batchResult{}
+	p.assert.NoError(json.Unmarshal([]byte(res), r))
+
+	results := make([]string, 0, len(r.Results))
+	for _, e := range r.Results {
+		results = append(results, e.Result)
+	}
+	return results
+}
+
+func Test_MkdirMany(t *testing.T) {
+	t.Run("children before parents", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"mkdir_many": [{"dest": "%s"}, {"dest": "%s"}, {"dest": "%s"}]}`,
+			p.fs.path(testDir1Dir2+"/"+testDir1),
+			p.fs.path(testDir1Dir2),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testResTrue, testResTrue, testResTrue}, mkdirManyResults(p, res))
+		p.assert.True(p.fs.dir(testDir1Dir2 + "/" + testDir1).exists())
+	}))
+
+	t.Run("exists and error", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"mkdir_many": [{"dest": "%s"}, {"dest": "%s"}, {"dest": "%s"}, {"dest": "%s"}]}`,
+			p.fs.path(testDir1),
+			p.fs.path(testFile1),
+			p.fs.path(testDir2+"/"+testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.NoError(err)
+
+		r := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal([]string{"exists", testResFalse, testResTrue, testResTrue}, mkdirManyResults(p, res))
+		p.assert.Equal(3, r.Succeeded)
+		p.assert.Equal(1, r.Failed)
+		p.assert.NotNil(r.Results[1].Error)
+	}))
+
+	t.Run("missing parent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"mkdir_many": [{"dest": "%s"}]}`,
+			p.fs.path(testDir1Dir2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testResFalse}, mkdirManyResults(p, res))
+	}))
+
+	t.Run("perm", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"mkdir_many": [{"dest": "%s"}, {"dest": "%s", "perm": %d}], "perm": %d}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2),
+			0700,
+			0750))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testResTrue, testResTrue}, mkdirManyResults(p, res))
+
+		st, err := os.Stat(p.fs.path(testDir1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.FileMode(0750), st.Mode().Perm())
+
+		st, err = os.Stat(p.fs.path(testDir2))
+		p.assert.NoError(err)
+		p.assert.Equal(os.FileMode(0700), st.Mode().Perm())
+	}))
+}
+
+func Test_MkdirMany_Speculate(t *testing.T) {
+	t.Run("speculative directory", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"mkdir_many": [{"dest": "%s"}, {"dest": "%s"}]}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir1+"/"+testDir2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testResTrue, testResTrue}, mkdirManyResults(p, res))
+
+		p.sess.finalize()
+		p.assert.ElementsMatch([]string{testDir2}, p.fs.dir(testDir1).ls())
+	}))
+
+	t.Run("speculative directory exists", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"mkdir_many": [{"dest": "%s"}]}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{"exists"}, mkdirManyResults(p, res))
+	}))
+}
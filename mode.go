@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// specialBits are the mode bits dropped by FileMode.Perm.
+const specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// taskMode converts the Unix mode given by a client. Setuid, setgid, and
+// sticky bits are kept only if full is set.
+func taskMode(raw uint32, full bool) os.FileMode {
+	m := os.FileMode(raw).Perm()
+	if !full {
+		return m
+	}
+
+	if raw&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if raw&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if raw&01000 != 0 {
+		m |= os.ModeSticky
+	}
+
+	return m
+}
+
+// modeBits returns the permission and the special bits of the mode.
+func modeBits(m os.FileMode) os.FileMode {
+	return m & (os.ModePerm | specialBits)
+}
+
+// permEqual reports whether the current mode satisfies the wanted one.
+// Special bits are compared only if the wanted mode has any, so that a plain
+// perm never clears them.
+func permEqual(current, want os.FileMode) bool {
+	if want&specialBits == 0 {
+		return current.Perm() == want
+	}
+
+	return modeBits(current) == want
+}
+
+// ensurePerm changes the mode of the file only if it differs, to save a
+// metadata write, and reports whether it did. If exact is set, special bits
+// missing from perm are cleared too; otherwise they are compared like
+// permEqual does.
+func (s *session) ensurePerm(destPath string, perm os.FileMode, exact bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("ensurePerm took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if exact && modeBits(fi.Mode()) == perm || !exact && permEqual(fi.Mode(), perm) {
+		return valUnchanged, nil
+	}
+
+	if err := os.Chmod(destPath, perm); err != nil {
+		return valFalse, err
+	}
+
+	return valChanged, nil
+}
+
+// cloneMeta gives dest the mode, the owner, and the group of src, leaving
+// the content as is.
+func (s *session) cloneMeta(srcPath, destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("cloneMeta took %s", time.Since(start))
+	}()
+
+	for _, path := range []string{srcPath, destPath} {
+		if exists, found := s.speculativeExistence(path); found && !exists {
+			return valFalse, fmt.Errorf("no such file or directory: %s", path)
+		}
+	}
+
+	src, err := os.Stat(srcPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	dest, err := os.Stat(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	srcUID, srcGID, err := statOwner(src)
+	if err != nil {
+		return valFalse, err
+	}
+
+	destUID, destGID, err := statOwner(dest)
+	if err != nil {
+		return valFalse, err
+	}
+
+	// Chown first since it may clear setuid and setgid bits.
+	if srcUID != destUID || srcGID != destGID {
+		if err := os.Chown(destPath, srcUID, srcGID); err != nil {
+			return valFalse, err
+		}
+	}
+
+	if err := os.Chmod(destPath, modeBits(src.Mode())); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
@@ -0,0 +1,254 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_FullMode(t *testing.T) {
+	t.Run("mkdir setgid", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "mkdir": true, "perm": %d, "full_mode": true}`,
+			p.fs.path(testDir1),
+			02775))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		st, err := os.Stat(p.fs.path(testDir1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.ModeSetgid|0775, modeBits(st.Mode()))
+	}))
+
+	t.Run("create sticky", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "perm": %d, "full_mode": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1),
+			01644))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		st, err := os.Stat(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.ModeSticky|0644, modeBits(st.Mode()))
+	}))
+
+	t.Run("dropped by default", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "mkdir": true, "perm": %d}`,
+			p.fs.path(testDir1),
+			02775))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		st, err := os.Stat(p.fs.path(testDir1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.FileMode(0775), modeBits(st.Mode()))
+	}))
+}
+
+func Test_FullMode_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "perm": %d, "full_mode": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1),
+			02644))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		st, err := os.Stat(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.ModeSetgid|0644, modeBits(st.Mode()))
+	}))
+}
+
+func Test_EnsurePerm(t *testing.T) {
+	t.Run("changed", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile1).chmod(0600)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			0644))
+
+		p.assert.NoError(err)
+		p.assert.Equal("changed", res)
+
+		st, err := os.Stat(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.FileMode(0644), modeBits(st.Mode()))
+	}))
+
+	t.Run("unchanged", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile1).chmod(0644)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			0644))
+
+		p.assert.NoError(err)
+		p.assert.Equal("unchanged", res)
+	}))
+
+	t.Run("full mode clears setgid", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.assert.NoError(os.Chmod(p.fs.path(testDir1), os.ModeSetgid|0755))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d}`,
+			p.fs.path(testDir1),
+			0755))
+
+		p.assert.NoError(err)
+		p.assert.Equal("unchanged", res)
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d, "full_mode": true}`,
+			p.fs.path(testDir1),
+			0755))
+
+		p.assert.NoError(err)
+		p.assert.Equal("changed", res)
+
+		st, err := os.Stat(p.fs.path(testDir1))
+		p.assert.NoError(err)
+		p.assert.Equal(os.ModeDir|0755, st.Mode())
+	}))
+
+	t.Run("no perm", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			0644))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			0644))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_CloneMeta(t *testing.T) {
+	t.Run("mode", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+		p.assert.NoError(os.Chmod(p.fs.path(testFile1), 0640))
+		p.assert.NoError(os.Chmod(p.fs.path(testFile2), 0604))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone_meta": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+
+		st, err := os.Stat(p.fs.path(testFile2))
+		p.assert.NoError(err)
+		p.assert.Equal(os.FileMode(0640), modeBits(st.Mode()))
+	}))
+
+	t.Run("owner", run(func(p *testpack) {
+		if os.Geteuid() != 0 {
+			t.Skip("chown requires root")
+		}
+
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+		p.assert.NoError(os.Chown(p.fs.path(testFile1), 1234, 5678))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone_meta": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		st, err := os.Stat(p.fs.path(testFile2))
+		p.assert.NoError(err)
+		uid, gid, err := statOwner(st)
+		p.assert.NoError(err)
+		p.assert.Equal(1234, uid)
+		p.assert.Equal(5678, gid)
+	}))
+
+	t.Run("no src", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clone_meta": true}`,
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone_meta": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_CloneMeta_Speculate(t *testing.T) {
+	t.Run("speculative new dest", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "clone_meta": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
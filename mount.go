@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// isMountpoint reports whether the path is on a different device from its
+// parent. Bind mounts of the same device can't be detected this way.
+func isMountpoint(path string) (bool, error) {
+	if path == "/" {
+		return true, nil
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+
+	// A symbolic link is never a mountpoint itself.
+	if !fi.IsDir() {
+		return false, nil
+	}
+
+	parent, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return false, err
+	}
+
+	dev, err := statDevice(fi)
+	if err != nil {
+		return false, err
+	}
+
+	parentDev, err := statDevice(parent)
+	if err != nil {
+		return false, err
+	}
+
+	return dev != parentDev, nil
+}
+
+func (s *session) isMountpoint(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("isMountpoint took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	mp, err := isMountpoint(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if mp {
+		return valTrue, nil
+	}
+	return valFalse, nil
+}
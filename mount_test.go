@@ -0,0 +1,118 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func Test_IsMountpoint(t *testing.T) {
+	t.Run("root", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"dest": "/", "is_mountpoint": true}`))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("ordinary directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "is_mountpoint": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "is_mountpoint": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "is_mountpoint": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+// simulateMount makes the directory of the name look like it's on another
+// device until the returned function is called.
+func simulateMount(name string) func() {
+	orig := fileDevice
+	fileDevice = func(fi os.FileInfo) (uint64, error) {
+		dev, err := orig(fi)
+		if fi.Name() == name {
+			return dev + 1, err
+		}
+		return dev, err
+	}
+
+	return func() {
+		fileDevice = orig
+	}
+}
+
+func Test_DeleteRecursive_SameFSOnly(t *testing.T) {
+	t.Run("boundary respected", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		defer simulateMount(testDir2)()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete_recursive": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(testDir1Dir2File1).exists())
+	}))
+
+	t.Run("boundary respected in speculative tree", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1Dir2File2)))
+		p.sess.done(context.Background())
+
+		defer simulateMount(testDir2)()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete_recursive": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(testDir1Dir2File1).exists())
+	}))
+
+	t.Run("boundary crossed if disabled", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		defer simulateMount(testDir2)()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete_recursive": true, "same_fs_only": false}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.dir(testDir1).exists())
+	}))
+}
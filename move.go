@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// move renames src to dest, falling back to copy and delete across devices.
+// If replaceDir is set, an existing destination directory is deleted first
+// so that a directory can take its place.
+func (s *session) move(srcPath, destPath string, replaceDir bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("move took %s", time.Since(start))
+	}()
+
+	if err := s.prepareMove(srcPath, destPath); err != nil {
+		return valFalse, err
+	}
+
+	if replaceDir && isDir(srcPath) && isDir(destPath) {
+		if err := s.replaceDir(srcPath, destPath); err != nil {
+			return valFalse, err
+		}
+	}
+
+	return s.finishMove(srcPath, destPath, os.Rename(srcPath, destPath))
+}
+
+// prepareMove updates the speculative tree so that the rename is safe.
+func (s *session) prepareMove(srcPath, destPath string) error {
+	if err := s.closeOpenFile(srcPath); err != nil {
+		return err
+	}
+
+	if exists, found := s.speculativeExistence(srcPath); found && !exists {
+		return fmt.Errorf("no such file or directory: %s", srcPath)
+	}
+
+	// The source is moving away; its speculative fd must not be disposed later.
+	if err := s.releaseSpeculativeFile(srcPath); err != nil {
+		return err
+	}
+
+	if _, err := s.takeOverDest(destPath); err != nil {
+		return err
+	}
+
+	// Renaming a directory would leave its speculative subtree pointing at
+	// stale paths. A replaced destination is taken care of by replaceDir.
+	if isDir(srcPath) {
+		if err := s.forgetSpeculativeDir(srcPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forgetSpeculativeDir disposes the unused speculations under the directory
+// and drops its subtree, so that later tasks under it open files on demand.
+func (s *session) forgetSpeculativeDir(absDirPath string) error {
+	d := s.findSpeculativeDir(absDirPath)
+	if d == nil || d.parent == nil {
+		return nil
+	}
+
+	d.forEachFile(func(f *speculativeFile) {
+		if f.lru != nil {
+			s.speculations.Remove(f.lru)
+			f.lru = nil
+		}
+	})
+
+	if err := d.clean(); err != nil {
+		return err
+	}
+	delete(d.parent.childDirs, d.name)
+
+	return nil
+}
+
+// forEachFile calls fn for every speculative file in the subtree.
+func (t *dirTree) forEachFile(fn func(*speculativeFile)) {
+	for _, f := range t.childFiles {
+		fn(f)
+	}
+
+	for _, d := range t.childDirs {
+		d.forEachFile(fn)
+	}
+}
+
+// finishMove falls back to copy and delete if the rename failed across devices.
+func (s *session) finishMove(srcPath, destPath string, renameErr error) (string, error) {
+	if renameErr == nil {
+		return valTrue, nil
+	}
+
+	if !errors.Is(renameErr, syscall.EXDEV) {
+		return valFalse, renameErr
+	}
+
+	log.Debugf("falling back to copy across devices: %s", srcPath)
+	return s.moveAcrossDevices(srcPath, destPath)
+}
+
+func (s *session) moveAcrossDevices(srcPath, destPath string) (string, error) {
+	st, err := os.Stat(srcPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if st.IsDir() {
+		return valFalse, fmt.Errorf("cannot move directory across devices: %s", srcPath)
+	}
+
+	perm := st.Mode().Perm()
+	res, err := s.copyFile(srcPath, destPath, &writeOptions{perm: &perm, overwrite: true})
+	if err != nil {
+		return res, err
+	}
+
+	if err := os.Remove(srcPath); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.IsDir()
+}
+
+// replaceDir deletes the destination directory, including what the
+// speculative tree holds under it, after checking it's safe to do so.
+func (s *session) replaceDir(srcPath, destPath string) error {
+	if isUnder(srcPath, destPath) {
+		return fmt.Errorf("cannot replace a directory containing the source: %s", destPath)
+	}
+
+	if s.isProtectedRoot(destPath) {
+		return fmt.Errorf("%w: cannot replace a root directory: %s", os.ErrPermission, destPath)
+	}
+
+	mp, err := isMountpoint(destPath)
+	if err != nil {
+		return err
+	}
+	if mp {
+		return fmt.Errorf("%w: cannot replace a mountpoint: %s", os.ErrPermission, destPath)
+	}
+
+	if _, err := s.delete(destPath, true, true); err != nil {
+		return err
+	}
+
+	// Remove the speculations under the old destination now rather than on
+	// finalize, since the new directory takes its place.
+	if d := s.findSpeculativeDir(destPath); d != nil {
+		if err := d.clean(); err != nil {
+			return err
+		}
+		delete(d.parent.childDirs, d.name)
+	}
+
+	return nil
+}
+
+// isProtectedRoot reports whether the path is one of the directories paths
+// are confined to, which must never be replaced as a whole.
+func (s *session) isProtectedRoot(absPath string) bool {
+	if absPath == "/" || absPath == s.cfg.root {
+		return true
+	}
+
+	for _, prefix := range s.cfg.allowPrefixes {
+		if absPath == prefix {
+			return true
+		}
+	}
+
+	return false
+}
+
+// takeOverDest prepares for the file at the path to be replaced or written
+// by other means than its speculative fd. The file kept open by this session
+// is closed, the speculative file is released so that finalize never disposes
+// of it, and its directory is committed as existent. deleted reports whether
+// the file was deleted only in the speculative tree, so it must start empty.
+func (s *session) takeOverDest(absPath string) (deleted bool, err error) {
+	if exists, found := s.speculativeExistence(absPath); found && !exists {
+		deleted = true
+	}
+
+	if err := s.closeOpenFile(absPath); err != nil {
+		return false, err
+	}
+
+	if err := s.releaseSpeculativeFile(absPath); err != nil {
+		return false, err
+	}
+	s.commitSpeculativeDir(filepath.Dir(absPath))
+
+	return deleted, nil
+}
+
+// releaseSpeculativeFile claims the speculative file at the path, if any,
+// and closes it without removing.
+func (s *session) releaseSpeculativeFile(absPath string) error {
+	f := s.useSpeculativeFile(absPath)
+	if f == nil || f.err != nil {
+		return nil
+	}
+
+	return f.file.Close()
+}
+
+// commitSpeculativeDir marks the directory and its ancestors as existent.
+func (s *session) commitSpeculativeDir(absDirPath string) {
+	t := s.speculativeDirTree
+	if absDirPath == "/" {
+		return
+	}
+
+	for _, name := range strings.Split(absDirPath[1:], "/") {
+		d, ok := t.childDirs[name]
+		if !ok {
+			return
+		}
+		d.speculative = false
+		t = d
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_Move(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal([]string{testFile2}, p.fs.dir(testRootDir).ls())
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("overwrite", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testDir2),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal([]string{testDir2}, p.fs.dir(testRootDir).ls())
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testDir2).ls())
+	}))
+
+	t.Run("into", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir2).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "into": true}`,
+			p.fs.path(testDir2),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal([]string{testDir2}, p.fs.dir(testRootDir).ls())
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testDir2+"/"+testDir1).ls())
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Move_Speculate(t *testing.T) {
+	t.Run("speculative dest kept", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("into speculative directory", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "existence": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testDir1).ls())
+	}))
+
+	t.Run("speculative new source", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("speculative existing source", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal([]string{testFile2}, p.fs.dir(testRootDir).ls())
+	}))
+}
+
+func Test_Move_SpeculateDir(t *testing.T) {
+	movedFile1 := testDir2 + "/" + testFile1
+	movedFile2 := testDir2 + "/" + testFile2
+
+	t.Run("write into new path", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testDir1File1)))
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testDir2),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		for _, f := range []string{movedFile1, movedFile2} {
+			res, err = p.sess.addTask(taskf(
+				`{"dest": "%s", "content_b64": "%s"}`,
+				p.fs.path(f),
+				b64String(testContent2)))
+
+			p.assert.NoError(err)
+			p.assert.Equal(testResTrue, res)
+		}
+
+		p.sess.finalize()
+		p.assert.False(p.fs.dir(testDir1).exists())
+		p.assert.Equal(testContent2, p.fs.file(movedFile1).read())
+		p.assert.Equal(testContent2, p.fs.file(movedFile2).read())
+	}))
+
+	t.Run("unused speculation", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testDir2),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		res, err = p.sess.addTask(taskf(`{"dest": "%s", "existence": true}`, p.fs.path(movedFile2)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+
+		p.sess.finalize()
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testDir2).ls())
+		p.assert.Equal(0, p.sess.speculations.Len())
+	}))
+}
+
+func Test_Move_ReplaceDir(t *testing.T) {
+	setup := func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.dir(testDir2).create()
+		p.fs.file(testDir2 + "/" + testFile2).write(testContent2)
+	}
+
+	t.Run("typical", run(func(p *testpack) {
+		setup(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "overwrite": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal([]string{testDir1}, p.fs.dir(testRootDir).ls())
+		p.assert.Equal([]string{testFile2}, p.fs.dir(testDir1).ls())
+	}))
+
+	t.Run("not by default", run(func(p *testpack) {
+		setup(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("allowed root", run(func(p *testpack) {
+		setup(p)
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1), p.fs.path(testDir2)}
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "overwrite": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("speculative files in destination", run(func(p *testpack) {
+		setup(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1Dir2File1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true, "overwrite": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal([]string{testDir1}, p.fs.dir(testRootDir).ls())
+		p.assert.Equal([]string{testFile2}, p.fs.dir(testDir1).ls())
+	}))
+}
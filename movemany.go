@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// moveManyConcurrency caps the concurrent renames of a move_many task.
+const moveManyConcurrency = 16
+
+type movePair struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+}
+
+// moveMany moves each pair like move without replaceDir, renaming
+// concurrently, and reports the result of each pair in the same order.
+//
+// There is no ordering or atomicity across pairs: a failed pair doesn't undo
+// or stop the others, and pairs sharing a path race with each other.
+func (s *session) moveMany(pairs []movePair) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("moveMany took %s", time.Since(start))
+	}()
+
+	srcPaths := make([]string, len(pairs))
+	destPaths := make([]string, len(pairs))
+	errs := make([]error, len(pairs))
+	renameErrs := make([]error, len(pairs))
+
+	// The speculative tree isn't goroutine-safe; only rename concurrently.
+	for i, p := range pairs {
+		if srcPaths[i], errs[i] = s.normalizePath(p.Src); errs[i] != nil {
+			continue
+		}
+		if destPaths[i], errs[i] = s.normalizePath(p.Dest); errs[i] != nil {
+			continue
+		}
+		errs[i] = s.prepareMove(srcPaths[i], destPaths[i])
+	}
+
+	eg := &errgroup.Group{}
+	eg.SetLimit(moveManyConcurrency)
+	for i := range pairs {
+		i := i
+		if errs[i] != nil {
+			continue
+		}
+
+		eg.Go(func() error {
+			renameErrs[i] = os.Rename(srcPaths[i], destPaths[i])
+			return nil
+		})
+	}
+	eg.Wait()
+
+	res := &batchResult{
+		Results: make([]*batchEntry, 0, len(pairs)),
+	}
+	for i := range pairs {
+		r := valFalse
+		err := errs[i]
+		if err == nil {
+			r, err = s.finishMove(srcPaths[i], destPaths[i], renameErrs[i])
+		}
+
+		entry := &batchEntry{Result: r}
+		if err != nil {
+			msg := err.Error()
+			entry.Error = &msg
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+		res.Results = append(res.Results, entry)
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
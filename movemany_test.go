@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func Test_MoveMany(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"move_many": [{"src": "%s", "dest": "%s"}, {"src": "%s", "dest": "%s"}]}`,
+			p.fs.path(testFile1),
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile2),
+			p.fs.path(testDir1File2)))
+
+		p.assert.NoError(err)
+
+		r := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal(2, r.Succeeded)
+		p.assert.Equal(0, r.Failed)
+		p.assert.False(p.fs.file(testFile1).exists())
+		p.assert.False(p.fs.file(testFile2).exists())
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+		p.assert.Equal(testContent2, p.fs.file(testDir1File2).read())
+	}))
+
+	t.Run("partial failure", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"move_many": [{"src": "%s", "dest": "%s"}, {"src": "%s", "dest": "%s"}]}`,
+			p.fs.path(testFile2),
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+
+		r := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal(1, r.Succeeded)
+		p.assert.Equal(1, r.Failed)
+		p.assert.Equal(testResFalse, r.Results[0].Result)
+		p.assert.NotNil(r.Results[0].Error)
+		p.assert.Equal(testResTrue, r.Results[1].Result)
+		p.assert.Nil(r.Results[1].Error)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("empty", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"move_many": []}`))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"results":[],"succeeded":0,"failed":0}`, res)
+	}))
+}
+
+func Test_MoveMany_Speculate(t *testing.T) {
+	t.Run("speculative new destination", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"move_many": [{"src": "%s", "dest": "%s"}]}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+
+		r := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal(1, r.Succeeded)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("speculative new source", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"move_many": [{"src": "%s", "dest": "%s"}]}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+
+		r := &batchResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal(1, r.Failed)
+
+		p.sess.finalize()
+		p.assert.False(p.fs.file(testFile1).exists())
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+}
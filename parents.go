@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// makeParents creates the missing ancestors of destPath with perm, or 0755
+// subject to umask if nil, like createDirTree does for speculation.
+// Ancestors that exist only speculatively become real.
+func (s *session) makeParents(destPath string, perm *os.FileMode) error {
+	dir := filepath.Dir(destPath)
+	s.commitSpeculativeDir(dir)
+
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		_, err := os.Stat(d)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		missing = append(missing, d)
+		if d == "/" {
+			break
+		}
+	}
+
+	newPerm := os.FileMode(0755)
+	if perm != nil {
+		newPerm = *perm
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], newPerm); err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return err
+		}
+
+		// Mkdir is subject to umask.
+		if perm != nil {
+			if err := os.Chmod(missing[i], *perm); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+)
+
+// speculationPool shares speculative files among sessions so that a file
+// speculated by one connection can be claimed by another.
+//
+// A speculative file is owned by the session that created it. The owner keeps
+// it in its own speculativeDirTree and disposes it on finalize unless another
+// session claimed it first. A claiming session takes over the file descriptor
+// and closes it as part of its own write, so each speculation is finalized
+// exactly once by whichever session claims it first, the owner included.
+// The owner drops a file claimed by another session from its tree, and
+// withdraws a file from the pool before changing it.
+// Once the owner finalizes, its unclaimed speculations leave the pool.
+type speculationPool struct {
+	mux   sync.Mutex
+	files map[string]*speculativeFile
+}
+
+func newSpeculationPool() *speculationPool {
+	return &speculationPool{
+		files: map[string]*speculativeFile{},
+	}
+}
+
+// sharedSpeculations is used only when shared speculation is enabled.
+var sharedSpeculations = newSpeculationPool()
+
+func (p *speculationPool) register(absPath string, file *speculativeFile) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	file.pooled = true
+	p.files[absPath] = file
+}
+
+// take removes the speculative file from the pool and returns it claimed.
+// Claiming under the lock lets the owner withdraw the file reliably.
+func (p *speculationPool) take(absPath string) *speculativeFile {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	file, ok := p.files[absPath]
+	if !ok {
+		return nil
+	}
+
+	delete(p.files, absPath)
+	if !file.claim() {
+		return nil
+	}
+
+	return file
+}
+
+// remove removes the speculative file only if it's still registered.
+func (p *speculationPool) remove(absPath string, file *speculativeFile) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.files[absPath] == file {
+		delete(p.files, absPath)
+	}
+}
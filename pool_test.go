@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_SharedSpeculation(t *testing.T) {
+	t.Run("claimed by another session", run(func(p *testpack) {
+		p.sess.cfg.sharedSpeculation = true
+		other := newSession(p.sess.cfg)
+		defer other.finalize()
+
+		other.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		other.finalize()
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("dropped from owner once claimed", run(func(p *testpack) {
+		p.sess.cfg.sharedSpeculation = true
+		other := newSession(p.sess.cfg)
+		defer other.finalize()
+
+		other.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		res, err := other.addTask(taskf(
+			`{"dest": "%s", "existence": true}`,
+			p.fs.path(testFile1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		res, err = other.addTask(taskf(
+			`{"dest": "%s", "delete": true}`,
+			p.fs.path(testFile1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("withdrawn before change", run(func(p *testpack) {
+		p.sess.cfg.sharedSpeculation = true
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Nil(sharedSpeculations.take(p.fs.path(testFile1)))
+	}))
+
+	t.Run("claimed by owner", run(func(p *testpack) {
+		p.sess.cfg.sharedSpeculation = true
+		other := newSession(p.sess.cfg)
+		defer other.finalize()
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.Nil(sharedSpeculations.take(p.fs.path(testFile1)))
+	}))
+
+	t.Run("disposed by owner", run(func(p *testpack) {
+		p.sess.cfg.sharedSpeculation = true
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.finalize()
+
+		p.assert.Nil(sharedSpeculations.take(p.fs.path(testFile1)))
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("not shared by default", run(func(p *testpack) {
+		other := newSession(p.sess.cfg)
+		defer other.finalize()
+
+		other.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Nil(sharedSpeculations.take(p.fs.path(testFile1)))
+	}))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// populateConcurrency caps the concurrent writes of a populate task.
+const populateConcurrency = 16
+
+// fileContents maps file names to their contents in base64.
+type fileContents map[string]content
+
+type populateResult struct {
+	Results   map[string]*batchEntry `json:"results"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+}
+
+// validPopulateName reports whether the name is a plain file name, never
+// reaching outside the directory.
+func validPopulateName(name string) bool {
+	return name != "" && name != "." && !strings.Contains(name, "/") && !strings.Contains(name, "..")
+}
+
+// populate creates the directory if missing and writes the files in it,
+// reporting the result of each one by name. Files the speculative tree or
+// the session knows are written in order through it; others concurrently.
+// Each file is read back if verify is set.
+func (s *session) populate(dirPath string, files fileContents, dirPerm *os.FileMode, opts *writeOptions, verify bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("populate took %s", time.Since(start))
+	}()
+
+	// Refuse as a whole rather than leave a partial scaffold.
+	for name := range files {
+		if !validPopulateName(name) {
+			return valFalse, fmt.Errorf("invalid name to populate: %q", name)
+		}
+	}
+
+	if !s.existence(dirPath) {
+		if opts.makeParents {
+			if err := s.makeParents(dirPath, opts.dirPerm); err != nil {
+				return valFalse, err
+			}
+		}
+
+		if err := s.mkdir(dirPath, dirPerm); err != nil {
+			return valFalse, err
+		}
+	}
+
+	check := func(res string, err error, path string, c content) (string, error) {
+		if !verify {
+			return res, err
+		}
+
+		return s.verifyWritten(res, err, path, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(c)), nil
+		})
+	}
+
+	results := make(map[string]*batchEntry, len(files))
+	errs := make(map[string]error, len(files))
+	for name := range files {
+		results[name] = &batchEntry{}
+	}
+
+	// The speculative tree isn't goroutine-safe; only touch disk concurrently.
+	eg := &errgroup.Group{}
+	eg.SetLimit(populateConcurrency)
+	for name, c := range files {
+		name, c := name, c
+		path := dirPath + "/" + name
+		if _, open := s.openFiles[path]; open || opts.atomic || s.findSpeculativeFile(path) != nil {
+			res, err := s.createFile(c, path, opts)
+			results[name].Result, errs[name] = check(res, err, path, c)
+			continue
+		}
+
+		entry := results[name]
+		eg.Go(func() error {
+			res, err := s.createFileOnDisk(c, path, opts)
+			entry.Result, err = check(res, err, path, c)
+			if err != nil {
+				msg := err.Error()
+				entry.Error = &msg
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+
+	res := &populateResult{Results: results}
+	for name, entry := range results {
+		if err := errs[name]; err != nil {
+			msg := err.Error()
+			entry.Error = &msg
+		}
+
+		if entry.Error != nil {
+			log.Error(*entry.Error)
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
+
+// createFileOnDisk is createFile for a file neither the speculative tree nor
+// the session knows about, and is safe to call concurrently.
+func (s *session) createFileOnDisk(content []byte, destPath string, opts *writeOptions) (string, error) {
+	if err := s.checkWriteSize(int64(len(content))); err != nil {
+		return valFalse, err
+	}
+
+	dest, created, err := createDestOnDisk(destPath, opts)
+	if err != nil {
+		if errors.Is(err, errExists) {
+			return valExists, nil
+		}
+		return valFalse, err
+	}
+	defer func() {
+		if err := dest.Close(); err != nil {
+			log.Errorf("failed to close: %s", destPath)
+		}
+	}()
+
+	return s.writeDest(dest, created, content, destPath, opts)
+}
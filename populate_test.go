@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func Test_Populate(t *testing.T) {
+	populate := func(p *testpack, params string) (*populateResult, error) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "populate": {"%s": "%s", "%s": "%s"}%s}`,
+			p.fs.path(testDir1),
+			testFile1, b64String(testContent1),
+			testFile2, b64String(testContent2),
+			params))
+		if err != nil {
+			return nil, err
+		}
+
+		r := &populateResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		return r, nil
+	}
+
+	t.Run("new directory", run(func(p *testpack) {
+		r, err := populate(p, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(2, r.Succeeded)
+		p.assert.Equal(testResTrue, r.Results[testFile1].Result)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+		p.assert.Equal(testContent2, p.fs.file(testDir1File2).read())
+	}))
+
+	t.Run("existing directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent2)
+
+		r, err := populate(p, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(2, r.Succeeded)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("perm", run(func(p *testpack) {
+		_, err := populate(p, fmt.Sprintf(`, "perm": %d`, testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testFilePerm1, p.fs.file(testDir1File2).mode())
+	}))
+
+	t.Run("exclusive", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent2)
+
+		r, err := populate(p, `, "create_exclusive": true`)
+
+		p.assert.NoError(err)
+		p.assert.Equal(valExists, r.Results[testFile1].Result)
+		p.assert.Equal(testResTrue, r.Results[testFile2].Result)
+		p.assert.Equal(testContent2, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("no space", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent2)
+
+		defer failWriteWithNoSpace()()
+		r, err := populate(p, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(2, r.Failed)
+		p.assert.True(p.fs.file(testDir1File1).exists())
+		p.assert.False(p.fs.file(testDir1File2).exists())
+	}))
+
+	t.Run("verify after write", run(func(p *testpack) {
+		orig := writeFile
+		writeFile = func(file *os.File, b []byte) (int, error) {
+			return file.Write(bytes.ToUpper(b))
+		}
+		defer func() {
+			writeFile = orig
+		}()
+
+		r, err := populate(p, `, "verify_after_write": true`)
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, r.Results[testFile1].Result)
+		p.assert.Equal(testResFalse, r.Results[testFile2].Result)
+	}))
+
+	t.Run("invalid name", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "populate": {"%s": "%s", "../%s": "%s"}}`,
+			p.fs.path(testDir1),
+			testFile1, b64String(testContent1),
+			testFile2, b64String(testContent2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.dir(testDir1).exists())
+	}))
+
+	t.Run("missing parent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "populate": {"%s": "%s"}}`,
+			p.fs.path(testDir1Dir2),
+			testFile1, b64String(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("make parents", run(func(p *testpack) {
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "populate": {"%s": "%s"}, "make_parents": true}`,
+			p.fs.path(testDir1Dir2),
+			testFile1, b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testContent1, p.fs.file(testDir1Dir2File1).read())
+	}))
+}
+
+func Test_Populate_Speculate(t *testing.T) {
+	t.Run("speculative file used", run(func(p *testpack) {
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "populate": {"%s": "%s", "%s": "%s"}}`,
+			p.fs.path(testDir1),
+			testFile1, b64String(testContent1),
+			testFile2, b64String(testContent2)))
+		p.assert.NoError(err)
+
+		r := &populateResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal(2, r.Succeeded)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+		p.assert.Equal(testContent2, p.fs.file(testDir1File2).read())
+	}))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profile is a set of tuning defaults suited to a kind of filesystem.
+type profile struct {
+	copyBufferSize       int
+	speculateConcurrency int
+	cleanConcurrency     int
+}
+
+var profiles = map[string]profile{
+	// Bursting EFS has limited throughput credits; avoid flooding it.
+	"bursting": {
+		copyBufferSize:       256 * 1024,
+		speculateConcurrency: 64,
+		cleanConcurrency:     32,
+	},
+	// Provisioned EFS rewards large I/O and high parallelism.
+	"provisioned": {
+		copyBufferSize:       1024 * 1024,
+		speculateConcurrency: 256,
+		cleanConcurrency:     128,
+	},
+	// Local disks gain little from parallelism due to low latency.
+	"local": {
+		copyBufferSize:       64 * 1024,
+		speculateConcurrency: 8,
+		cleanConcurrency:     8,
+	},
+}
+
+func profileNames() string {
+	names := make([]string, 0, len(profiles))
+	for n := range profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// applyProfile overwrites the tuning settings with the profile.
+func (c *config) applyProfile(name string) error {
+	p, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile: %s (available: %s)", name, profileNames())
+	}
+
+	c.copyBufferSize = p.copyBufferSize
+	c.speculateConcurrency = p.speculateConcurrency
+	c.cleanConcurrency = p.cleanConcurrency
+	return nil
+}
+
+// treeLimits bounds the concurrency of the speculative tree of a session.
+// A nil treeLimits means unlimited.
+type treeLimits struct {
+	speculate chan struct{} // Semaphore of speculative opens. Nil means unlimited.
+	clean     int           // Goroutines per directory in clean. Zero means unlimited.
+}
+
+func newTreeLimits(cfg *config) *treeLimits {
+	l := &treeLimits{
+		clean: cfg.cleanConcurrency,
+	}
+
+	if 0 < cfg.speculateConcurrency {
+		l.speculate = make(chan struct{}, cfg.speculateConcurrency)
+	}
+
+	return l
+}
+
+func (l *treeLimits) acquireSpeculate() {
+	if l != nil && l.speculate != nil {
+		l.speculate <- struct{}{}
+	}
+}
+
+func (l *treeLimits) releaseSpeculate() {
+	if l != nil && l.speculate != nil {
+		<-l.speculate
+	}
+}
+
+func (l *treeLimits) cleanLimit() int {
+	if l == nil || l.clean <= 0 {
+		return -1
+	}
+
+	return l.clean
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_ApplyProfile(t *testing.T) {
+	t.Run("known", run(func(p *testpack) {
+		cfg := defaultConfig()
+
+		p.assert.NoError(cfg.applyProfile("provisioned"))
+		p.assert.Equal(profiles["provisioned"].copyBufferSize, cfg.copyBufferSize)
+		p.assert.Equal(profiles["provisioned"].speculateConcurrency, cfg.speculateConcurrency)
+		p.assert.Equal(profiles["provisioned"].cleanConcurrency, cfg.cleanConcurrency)
+	}))
+
+	t.Run("unknown", run(func(p *testpack) {
+		cfg := defaultConfig()
+
+		p.assert.Error(cfg.applyProfile("unknown"))
+		p.assert.Equal(defaultConfig().copyBufferSize, cfg.copyBufferSize)
+	}))
+}
+
+func Test_Speculate_Limited(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		cfg := defaultConfig()
+		cfg.speculateConcurrency = 1
+		cfg.cleanConcurrency = 1
+		p.sess = newSession(cfg)
+
+		p.fs.dir(testDir1).create()
+		for _, f := range []string{testDir1File1, testDir1File2, testFile1} {
+			p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(f)))
+		}
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+		p.assert.False(p.fs.file(testDir1File2).exists())
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pruneEmptyParents removes the ancestors of path from the nearest one
+// upwards while they are empty, like clean does for speculative directories.
+// It stops at a non-empty directory, at stopAt if given, and at the
+// directories paths are confined to. It returns the number removed.
+func (s *session) pruneEmptyParents(path string, stopAt *string) (int, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("pruneEmptyParents took %s", time.Since(start))
+	}()
+
+	pruned := 0
+	for dir := filepath.Dir(path); !s.isProtectedRoot(dir); dir = filepath.Dir(dir) {
+		if stopAt != nil && (dir == *stopAt || !isUnder(dir, *stopAt)) {
+			break
+		}
+
+		removed, err := s.removeEmptyDir(dir)
+		if err != nil || !removed {
+			return pruned, err
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// removeEmptyDir removes the directory if it's logically empty.
+func (s *session) removeEmptyDir(dir string) (bool, error) {
+	if d := s.findSpeculativeDir(dir); d != nil {
+		if d.speculative {
+			return false, nil
+		}
+
+		names, err := d.logicalList()
+		if err != nil {
+			return false, err
+		}
+		if len(names) != 0 {
+			return false, nil
+		}
+
+		// Removed on finalize, together with unused speculations in it.
+		d.speculative = true
+		return true, nil
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Readdirnames(1)
+	f.Close()
+	if err != io.EOF {
+		return false, err
+	}
+
+	if err := os.Remove(dir); err != nil {
+		// Something was added in the meantime.
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func Test_PruneEmptyParents(t *testing.T) {
+	t.Run("up to root", run(func(p *testpack) {
+		p.sess.cfg.root = filepath.Clean(p.fs.baseDir)
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true, "prune_empty_parents": true}`,
+			p.fs.path(testDir1Dir2File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.dir(testDir1).exists())
+		p.assert.True(p.fs.dir(".").exists())
+	}))
+
+	t.Run("non-empty parent", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true, "prune_empty_parents": true}`,
+			p.fs.path(testDir1Dir2File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.dir(testDir1Dir2).exists())
+		p.assert.True(p.fs.file(testDir1File1).exists())
+	}))
+
+	t.Run("stop at", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true, "prune_empty_parents": true, "stop_at": "%s"}`,
+			p.fs.path(testDir1Dir2File1),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.dir(testDir1Dir2).exists())
+		p.assert.True(p.fs.dir(testDir1).exists())
+	}))
+
+	t.Run("without prune", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.True(p.fs.dir(testDir1).exists())
+	}))
+}
+
+func Test_PruneEmptyParents_Speculate(t *testing.T) {
+	t.Run("only speculative new files left", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true, "prune_empty_parents": true, "stop_at": "%s"}`,
+			p.fs.path(testDir1File1),
+			filepath.Clean(p.fs.path("."))))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		res, err = p.sess.addTask(taskf(`{"dest": "%s", "existence": true}`, p.fs.path(testDir1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+
+		p.sess.finalize()
+		p.assert.False(p.fs.dir(testDir1).exists())
+	}))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// realpath returns the path with every symbolic link resolved, so that
+// clients can send canonical paths. The result isn't checked against --root;
+// a task using it is.
+func (s *session) realpath(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("realpath took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valFalse, fmt.Errorf("%w: %s", os.ErrNotExist, destPath)
+	}
+
+	resolved, err := filepath.EvalSymlinks(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	j, err := json.Marshal(resolved)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
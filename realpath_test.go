@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Realpath(t *testing.T) {
+	realpath := func(p *testpack, path string) (string, error) {
+		return p.sess.addTask(taskf(`{"dest": "%s", "realpath": true}`, p.fs.path(path)))
+	}
+
+	t.Run("symbolic link resolved", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.assert.NoError(os.Symlink(p.fs.path(testDir1), p.fs.path(testDir2)))
+
+		res, err := realpath(p, testDir2+"/"+testFile1)
+		p.assert.NoError(err)
+
+		var resolved string
+		p.assert.NoError(json.Unmarshal([]byte(res), &resolved))
+		want, err := filepath.Abs(p.fs.path(testDir1File1))
+		p.assert.NoError(err)
+		p.assert.Equal(want, resolved)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := realpath(p, testFile1)
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := realpath(p, testFile1)
+
+		p.assert.ErrorIs(err, os.ErrNotExist)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// chmodRecursive applies the modes to dest and its descendants,
+// and returns the number of entries changed.
+func (s *session) chmodRecursive(destPath string, dirPerm, filePerm *os.FileMode) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("chmodRecursive took %s", time.Since(start))
+	}()
+
+	if dirPerm == nil && filePerm == nil {
+		return valFalse, fmt.Errorf("perm, dir_perm, or file_perm is required")
+	}
+
+	tree, exists := s.walkRoot(destPath)
+	if !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	var changed atomic.Int64
+	err := concurrentWalk(destPath, tree, func(path string, fi os.FileInfo) error {
+		var perm *os.FileMode
+		switch {
+		case fi.IsDir():
+			perm = dirPerm
+		case fi.Mode().IsRegular():
+			perm = filePerm
+		}
+
+		if perm == nil || permEqual(fi.Mode(), *perm) {
+			return nil
+		}
+
+		if err := os.Chmod(path, *perm); err != nil {
+			return err
+		}
+
+		changed.Add(1)
+		return nil
+	})
+	if err != nil {
+		return valFalse, err
+	}
+
+	return strconv.FormatInt(changed.Load(), 10), nil
+}
+
+type chownResult struct {
+	Succeeded int64 `json:"succeeded"`
+	Failed    int64 `json:"failed"`
+}
+
+// chownRecursive changes the ownership of dest and its descendants.
+// Failures on individual entries are counted and skipped if bestEffort is set,
+// otherwise the first failure aborts the walk.
+func (s *session) chownRecursive(destPath string, uid, gid *int, bestEffort bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("chownRecursive took %s", time.Since(start))
+	}()
+
+	if uid == nil && gid == nil {
+		return valFalse, fmt.Errorf("uid or gid is required")
+	}
+
+	// -1 leaves the ID unchanged.
+	u, g := -1, -1
+	if uid != nil {
+		u = *uid
+	}
+	if gid != nil {
+		g = *gid
+	}
+
+	tree, exists := s.walkRoot(destPath)
+	if !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	var succeeded, failed atomic.Int64
+	err := concurrentWalk(destPath, tree, func(path string, fi os.FileInfo) error {
+		if err := os.Lchown(path, u, g); err != nil {
+			failed.Add(1)
+			if bestEffort {
+				log.Warn(err)
+				return nil
+			}
+			return err
+		}
+
+		succeeded.Add(1)
+		return nil
+	})
+
+	if err != nil {
+		return valFalse, err
+	}
+
+	j, err := json.Marshal(&chownResult{
+		Succeeded: succeeded.Load(),
+		Failed:    failed.Load(),
+	})
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
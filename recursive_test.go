@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func Test_ChmodRecursive(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chmod_recursive": true, "dir_perm": %d, "file_perm": %d}`,
+			p.fs.path(testRootDir),
+			testDirPerm1,
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal("4", res)
+		p.assert.Equal(testDirPerm1, p.fs.dir(testRootDir).mode())
+		p.assert.Equal(testDirPerm1, p.fs.dir(testDir1).mode())
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+		p.assert.Equal(testFilePerm1, p.fs.file(testDir1File1).mode())
+	}))
+
+	t.Run("perm applies to both", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chmod_recursive": true, "perm": %d}`,
+			p.fs.path(testDir1),
+			testDirPerm2))
+
+		p.assert.NoError(err)
+		p.assert.Equal("2", res)
+		p.assert.Equal(testDirPerm2, p.fs.dir(testDir1).mode())
+		p.assert.Equal(testDirPerm2, p.fs.file(testDir1File1).mode())
+	}))
+
+	t.Run("unchanged entries not counted", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1).chmod(testFilePerm1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chmod_recursive": true, "file_perm": %d}`,
+			p.fs.path(testDir1),
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal("0", res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chmod_recursive": true, "perm": %d}`,
+			p.fs.path(testDir1),
+			testDirPerm1))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_ChmodRecursive_Speculate(t *testing.T) {
+	t.Run("speculative new entries skipped", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chmod_recursive": true, "file_perm": %d}`,
+			p.fs.path(testRootDir),
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal("1", res)
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+		p.assert.NotEqual(testFilePerm1, p.fs.file(testFile2).mode())
+	}))
+}
+
+func Test_ChownRecursive(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chown_recursive": true, "uid": %d, "gid": %d}`,
+			p.fs.path(testDir1),
+			os.Getuid(),
+			os.Getgid()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"succeeded":2,"failed":0}`, res)
+	}))
+
+	t.Run("ids required", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chown_recursive": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_ChownRecursive_Speculate(t *testing.T) {
+	t.Run("speculative new entries skipped", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "chown_recursive": true, "gid": %d}`,
+			p.fs.path(testDir1),
+			os.Getgid()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"succeeded":2,"failed":0}`, res)
+	}))
+}
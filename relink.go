@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// symlinkTempSibling creates a hidden symbolic link to target next to destPath.
+func symlinkTempSibling(target, destPath string) (string, error) {
+	for i := 0; i < maxTempAttempts; i++ {
+		name := tempSiblingName(destPath)
+		err := os.Symlink(target, name)
+		if os.IsExist(err) {
+			continue
+		}
+		return name, err
+	}
+
+	return "", fmt.Errorf("failed to create a temporary symbolic link for: %s", destPath)
+}
+
+// relink points the symbolic link at dest to target by renaming a new link
+// over it, so that readers never see dest missing. target is stored as is,
+// so a relative target is resolved against the directory of dest.
+// Anything other than a symbolic link at dest is replaced only if forced.
+func (s *session) relink(target, destPath string, force bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("relink took %s", time.Since(start))
+	}()
+
+	// A speculatively created file doesn't exist logically.
+	exists, found := s.speculativeExistence(destPath)
+	if !found || exists {
+		fi, err := os.Lstat(destPath)
+		if err != nil && !os.IsNotExist(err) {
+			return valFalse, err
+		}
+
+		if err == nil && fi.Mode()&os.ModeSymlink == 0 && !force {
+			return valFalse, fmt.Errorf("not a symbolic link: %s", destPath)
+		}
+	}
+
+	if _, err := s.takeOverDest(destPath); err != nil {
+		return valFalse, err
+	}
+
+	tmpPath, err := symlinkTempSibling(target, destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		if err := os.Remove(tmpPath); err != nil {
+			log.Warn(err)
+		}
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
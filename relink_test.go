@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func Test_Relink(t *testing.T) {
+	t.Run("new link", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true}`,
+			testFile1,
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("switch link", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir2).create()
+		p.assert.NoError(os.Symlink(testDir1, p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true}`,
+			testDir2,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		target, err := os.Readlink(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		p.assert.Equal(testDir2, target)
+	}))
+
+	t.Run("not a link", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true}`,
+			testFile2,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("not a link with force", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true, "force": true}`,
+			testFile2,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("target not allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir2).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true}`,
+			p.fs.path(testDir2),
+			p.fs.path(testDir1File1)))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal("null", res)
+		p.assert.False(p.fs.file(testDir1File1).exists())
+	}))
+
+	t.Run("relative target not allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "../%s", "dest": "%s", "relink": true}`,
+			testDir2,
+			p.fs.path(testDir1File1)))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("relative target allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true}`,
+			testFile2,
+			p.fs.path(testDir1File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("no src", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "relink": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+}
+
+func Test_Relink_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "relink": true}`,
+			testFile2,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+
+		target, err := os.Readlink(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		p.assert.Equal(testFile2, target)
+	}))
+}
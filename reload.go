@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configFile holds the settings that can be changed by editing the file given
+// with --config and sending SIGHUP. Omitted settings keep the flag values.
+type configFile struct {
+	Root           *string `json:"root"`
+	CopyBufferSize *int    `json:"copy_buffer_size"`
+	MaxWriteBytes  *int64  `json:"max_write_bytes"`
+	LogLevel       *string `json:"log_level"`
+	LogSample      *int    `json:"log_sample"`
+	MaxLogContent  *int    `json:"max_log_content"`
+	Quiet          *bool   `json:"quiet"`
+	Verbose        *bool   `json:"verbose"`
+}
+
+// applyFile overwrites the settings with those in the config file. Nothing
+// is changed if the file is invalid.
+func (c *config) applyFile(path string) error {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+
+	f := &configFile{}
+	if err := dec.Decode(f); err != nil {
+		return fmt.Errorf("invalid config file: %s: %w", path, err)
+	}
+
+	next := *c
+
+	if f.Root != nil {
+		if !filepath.IsAbs(*f.Root) {
+			return fmt.Errorf("root must be absolute: %s", *f.Root)
+		}
+		next.root = filepath.Clean(*f.Root)
+	}
+
+	if f.CopyBufferSize != nil {
+		if *f.CopyBufferSize <= 0 {
+			return fmt.Errorf("copy_buffer_size must be positive")
+		}
+		next.copyBufferSize = *f.CopyBufferSize
+	}
+
+	if f.MaxWriteBytes != nil {
+		next.maxWriteBytes = *f.MaxWriteBytes
+	}
+
+	if f.LogLevel != nil {
+		level, err := log.ParseLevel(*f.LogLevel)
+		if err != nil {
+			return err
+		}
+		next.logLevel = level
+	}
+
+	if f.LogSample != nil {
+		next.logSample = *f.LogSample
+	}
+
+	if f.MaxLogContent != nil {
+		next.maxLogContent = *f.MaxLogContent
+	}
+
+	if f.Quiet != nil {
+		next.quiet = *f.Quiet
+	}
+
+	if f.Verbose != nil {
+		next.verbose = *f.Verbose
+	}
+
+	*c = next
+	return nil
+}
+
+// reload builds the config from the flags and the config file, if any, again
+// and makes it the config of the tasks started from now on. Changes made by
+// admin tasks are discarded. On error, the current config is kept.
+func reload(flags *config, path string) {
+	cfg := *flags
+	if path != "" {
+		if err := cfg.applyFile(path); err != nil {
+			log.Errorf("failed to reload config: %s", err)
+			return
+		}
+	}
+
+	log.SetLevel(cfg.logLevel)
+	liveConfig.Store(&cfg)
+	log.Infof("reloaded config")
+}
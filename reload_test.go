@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func Test_ApplyFile(t *testing.T) {
+	writeConfig := func(p *testpack, content string) string {
+		path := filepath.Join(p.fs.baseDir, "config.json")
+		p.assert.NoError(os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	t.Run("typical", run(func(p *testpack) {
+		path := writeConfig(p, `{"root": "/srv/www/", "copy_buffer_size": 4096, "log_level": "warn", "max_log_content": 10}`)
+
+		cfg := defaultConfig()
+		cfg.quiet = true
+		p.assert.NoError(cfg.applyFile(path))
+		p.assert.Equal("/srv/www", cfg.root)
+		p.assert.Equal(4096, cfg.copyBufferSize)
+		p.assert.Equal(log.WarnLevel, cfg.logLevel)
+		p.assert.Equal(10, cfg.maxLogContent)
+		p.assert.True(cfg.quiet)
+	}))
+
+	t.Run("invalid value", run(func(p *testpack) {
+		path := writeConfig(p, `{"root": "/srv/www", "copy_buffer_size": 0}`)
+
+		cfg := defaultConfig()
+		p.assert.Error(cfg.applyFile(path))
+		p.assert.Equal("", cfg.root)
+		p.assert.Equal(defaultConfig().copyBufferSize, cfg.copyBufferSize)
+	}))
+
+	t.Run("relative root", run(func(p *testpack) {
+		path := writeConfig(p, `{"root": "www"}`)
+
+		p.assert.Error(defaultConfig().applyFile(path))
+	}))
+
+	t.Run("unknown field", run(func(p *testpack) {
+		path := writeConfig(p, `{"copy_bufer_size": 4096}`)
+
+		p.assert.Error(defaultConfig().applyFile(path))
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		p.assert.Error(defaultConfig().applyFile(filepath.Join(p.fs.baseDir, "config.json")))
+	}))
+}
+
+func Test_Reload(t *testing.T) {
+	writeConfig := func(p *testpack, content string) string {
+		path := filepath.Join(p.fs.baseDir, "config.json")
+		p.assert.NoError(os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	t.Run("swap for new tasks", run(func(p *testpack) {
+		defer liveConfig.Store(nil)
+		defer log.SetLevel(log.GetLevel())
+
+		root := filepath.Clean(p.fs.baseDir)
+		path := writeConfig(p, `{"root": "`+root+`"}`)
+
+		flags := defaultConfig()
+		liveConfig.Store(flags)
+		reload(flags, path)
+
+		p.assert.Equal(root, liveConfig.Load().root)
+		p.assert.Equal("", flags.root)
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "content_b64": "%s"}`, testFile1, b64String(testContent1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("invalid file keeps config", run(func(p *testpack) {
+		defer liveConfig.Store(nil)
+
+		path := writeConfig(p, `{"copy_buffer_size": -1}`)
+
+		flags := defaultConfig()
+		liveConfig.Store(flags)
+		reload(flags, path)
+
+		p.assert.Same(flags, liveConfig.Load())
+	}))
+
+	t.Run("flags only", run(func(p *testpack) {
+		defer liveConfig.Store(nil)
+		defer log.SetLevel(log.GetLevel())
+
+		flags := defaultConfig()
+		changed := *flags
+		changed.root = "/srv"
+		liveConfig.Store(&changed)
+		reload(flags, "")
+
+		p.assert.Equal("", liveConfig.Load().root)
+	}))
+}
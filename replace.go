@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxCopyReplaceBytes caps the source of copy_replace, which is held in
+// memory as a whole.
+const maxCopyReplaceBytes = 1024 * 1024
+
+// newReplacer returns a replacer trying longer old strings first so that
+// the result doesn't depend on the iteration order of the map.
+func newReplacer(replacements map[string]string) (*strings.Replacer, error) {
+	olds := make([]string, 0, len(replacements))
+	for old := range replacements {
+		if old == "" {
+			return nil, fmt.Errorf("empty string can't be replaced")
+		}
+		olds = append(olds, old)
+	}
+
+	sort.Slice(olds, func(i, j int) bool {
+		if len(olds[i]) != len(olds[j]) {
+			return len(olds[i]) > len(olds[j])
+		}
+		return olds[i] < olds[j]
+	})
+
+	pairs := make([]string, 0, len(olds)*2)
+	for _, old := range olds {
+		pairs = append(pairs, old, replacements[old])
+	}
+
+	return strings.NewReplacer(pairs...), nil
+}
+
+// copyReplace writes src to dest with the strings replaced. It's meant for
+// small text files such as configs; the source is read as a whole, and
+// binaries may be corrupted by accidental matches.
+func (s *session) copyReplace(srcPath, destPath string, replacements map[string]string, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("copyReplace took %s", time.Since(start))
+	}()
+
+	replacer, err := newReplacer(replacements)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if exists, found := s.speculativeExistence(srcPath); found && !exists {
+		return valFalse, fmt.Errorf("no such file: %s", srcPath)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return valFalse, err
+	}
+	defer src.Close()
+
+	// Read one byte more than the limit to detect a larger file.
+	content, err := io.ReadAll(io.LimitReader(src, maxCopyReplaceBytes+1))
+	if err != nil {
+		return valFalse, err
+	}
+
+	if maxCopyReplaceBytes < len(content) {
+		return valFalse, fmt.Errorf("source exceeds %d bytes: %s", maxCopyReplaceBytes, srcPath)
+	}
+
+	return s.createFile([]byte(replacer.Replace(string(content))), destPath, opts)
+}
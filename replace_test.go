@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_CopyReplace(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write("host={{HOST}}\nport={{PORT}}\nhost={{HOST}}\n")
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_replace": true, "replacements": {"{{HOST}}": "example.com", "{{PORT}}": "443"}}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("host=example.com\nport=443\nhost=example.com\n", p.fs.file(testFile2).read())
+		p.assert.Equal("host={{HOST}}\nport={{PORT}}\nhost={{HOST}}\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("longer match first", run(func(p *testpack) {
+		p.fs.file(testFile1).write("ab a")
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_replace": true, "replacements": {"a": "1", "ab": "2"}}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("2 1", p.fs.file(testFile2).read())
+	}))
+
+	t.Run("overwrite shorter", run(func(p *testpack) {
+		p.fs.file(testFile1).write("x")
+		p.fs.file(testFile2).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_replace": true, "replacements": {"x": "y"}}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("y", p.fs.file(testFile2).read())
+	}))
+
+	t.Run("empty old string", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_replace": true, "replacements": {"": "x"}}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+
+	t.Run("too large", run(func(p *testpack) {
+		p.fs.file(testFile1).write(strings.Repeat("a", maxCopyReplaceBytes+1))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_replace": true, "replacements": {"a": "b"}}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+}
+
+func Test_CopyReplace_Speculate(t *testing.T) {
+	t.Run("speculative new source", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "copy_replace": true, "replacements": {"a": "b"}}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
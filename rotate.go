@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const valRotated = "rotated"
+
+// pathLocker serializes operations on the same path across sessions.
+type pathLocker struct {
+	mux   sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	mux  sync.Mutex
+	refs int
+}
+
+func newPathLocker() *pathLocker {
+	return &pathLocker{
+		locks: map[string]*pathLock{},
+	}
+}
+
+// rotationLocks guards append_rotate so that a line is never appended to a
+// file that another session is rotating.
+var rotationLocks = newPathLocker()
+
+// lock locks the path and returns the function to unlock it.
+func (l *pathLocker) lock(absPath string) func() {
+	l.mux.Lock()
+	pl, ok := l.locks[absPath]
+	if !ok {
+		pl = &pathLock{}
+		l.locks[absPath] = pl
+	}
+	pl.refs++
+	l.mux.Unlock()
+
+	pl.mux.Lock()
+
+	return func() {
+		pl.mux.Unlock()
+
+		l.mux.Lock()
+		defer l.mux.Unlock()
+
+		pl.refs--
+		if pl.refs == 0 {
+			delete(l.locks, absPath)
+		}
+	}
+}
+
+// appendRotate appends content to the file, and then renames the file to
+// dest.1 if it has grown beyond maxBytes, so that the next append starts a
+// new file. It returns "rotated" when the file was renamed.
+func (s *session) appendRotate(content []byte, destPath string, maxBytes int64, perm *os.FileMode) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("appendRotate took %s", time.Since(start))
+	}()
+
+	if maxBytes <= 0 {
+		return valFalse, fmt.Errorf("invalid max_bytes: %d", maxBytes)
+	}
+
+	if err := s.checkWriteSize(int64(len(content))); err != nil {
+		return valFalse, err
+	}
+
+	rotatedPath := destPath + ".1"
+
+	flag := os.O_WRONLY | os.O_APPEND | os.O_CREATE
+
+	deleted, err := s.takeOverDest(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+	if deleted {
+		flag |= os.O_TRUNC
+	}
+
+	if _, err := s.takeOverDest(rotatedPath); err != nil {
+		return valFalse, err
+	}
+
+	newPerm := os.FileMode(0666)
+	if perm != nil {
+		newPerm = *perm
+	}
+
+	unlock := rotationLocks.lock(destPath)
+	defer unlock()
+
+	file, err := os.OpenFile(destPath, flag, newPerm)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if _, err := writeFile(file, content); err != nil {
+		file.Close()
+		return valFalse, err
+	}
+
+	st, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return valFalse, err
+	}
+
+	if err := file.Close(); err != nil {
+		return valFalse, err
+	}
+
+	if st.Size() <= maxBytes {
+		return valTrue, nil
+	}
+
+	if err := os.Rename(destPath, rotatedPath); err != nil {
+		return valFalse, err
+	}
+
+	return valRotated, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_AppendRotate(t *testing.T) {
+	appendRotate := func(p *testpack, content string, maxBytes int) string {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "append_rotate": true, "content_b64": "%s", "max_bytes": %d}`,
+			p.fs.path(testFile1),
+			b64String(content),
+			maxBytes))
+		p.assert.NoError(err)
+		return res
+	}
+
+	t.Run("append", run(func(p *testpack) {
+		p.assert.Equal(testResTrue, appendRotate(p, "a\n", 100))
+		p.assert.Equal(testResTrue, appendRotate(p, "b\n", 100))
+
+		p.assert.Equal("a\nb\n", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("rotate", run(func(p *testpack) {
+		p.assert.Equal(testResTrue, appendRotate(p, "a\n", 3))
+		p.assert.Equal("rotated", appendRotate(p, "b\n", 3))
+		p.assert.False(p.fs.file(testFile1).exists())
+		p.assert.Equal("a\nb\n", p.fs.file(testFile1+".1").read())
+
+		p.assert.Equal(testResTrue, appendRotate(p, "c\n", 3))
+		p.assert.Equal("c\n", p.fs.file(testFile1).read())
+		p.assert.Equal("a\nb\n", p.fs.file(testFile1+".1").read())
+	}))
+
+	t.Run("invalid max bytes", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "append_rotate": true, "content_b64": "%s", "max_bytes": 0}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("no max bytes", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "append_rotate": true, "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("concurrent sessions", run(func(p *testpack) {
+		const sessions, lines = 4, 50
+
+		wg := &sync.WaitGroup{}
+		for i := 0; i < sessions; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sess := newSession(defaultConfig())
+				defer sess.finalize()
+
+				for j := 0; j < lines; j++ {
+					sess.addTask(taskf(
+						`{"dest": "%s", "append_rotate": true, "content_b64": "%s", "max_bytes": 1000000}`,
+						p.fs.path(testFile1),
+						b64String("line\n")))
+				}
+			}()
+		}
+		wg.Wait()
+
+		p.assert.Equal(strings.Repeat("line\n", sessions*lines), p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_AppendRotate_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "append_rotate": true, "content_b64": "%s", "max_bytes": 100}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// errNoData means that no data region follows the offset.
+var errNoData = errors.New("no data after offset")
+
+// copySparse copies only the data regions of src so that holes stay holes
+// in dest. It returns errUnsupported, having written nothing, where holes
+// can't be located.
+func copySparse(dest, src *os.File, buf []byte) (int64, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("copySparse took %s", time.Since(start))
+	}()
+
+	st, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := st.Size()
+
+	// Probe before touching dest so that the caller can still fall back.
+	offset, err := seekData(src, 0)
+	if errors.Is(err, errNoData) {
+		offset = size
+	} else if err != nil {
+		return 0, err
+	}
+
+	// Old content would show through the holes otherwise.
+	if err := dest.Truncate(0); err != nil {
+		return 0, err
+	}
+
+	for offset < size {
+		end, err := seekHole(src, offset)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := copyRegion(dest, src, offset, end-offset, buf); err != nil {
+			return 0, err
+		}
+
+		offset, err = seekData(src, end)
+		if errors.Is(err, errNoData) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	// Extending the file makes the trailing hole, if any.
+	if err := dest.Truncate(size); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// copyRegion copies n bytes at offset of src to the same offset of dest.
+func copyRegion(dest, src *os.File, offset, n int64, buf []byte) error {
+	if _, err := dest.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := io.NewSectionReader(src, offset, n)
+	for {
+		rn, err := r.Read(buf)
+		if rn > 0 {
+			if _, err := writeFile(dest, buf[:rn]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// seekData returns the start of the first data region at or after offset,
+// or errNoData if only a hole remains.
+func seekData(file *os.File, offset int64) (int64, error) {
+	return seekRegion(file, offset, unix.SEEK_DATA)
+}
+
+// seekHole returns the start of the first hole at or after offset. The end
+// of the file counts as a hole.
+func seekHole(file *os.File, offset int64) (int64, error) {
+	return seekRegion(file, offset, unix.SEEK_HOLE)
+}
+
+func seekRegion(file *os.File, offset int64, whence int) (int64, error) {
+	pos, err := unix.Seek(int(file.Fd()), offset, whence)
+	if errors.Is(err, unix.ENXIO) {
+		return 0, errNoData
+	}
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EOPNOTSUPP) {
+		return 0, errUnsupported
+	}
+
+	return pos, err
+}
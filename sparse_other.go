@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+func seekData(file *os.File, offset int64) (int64, error) {
+	return 0, errUnsupported
+}
+
+func seekHole(file *os.File, offset int64) (int64, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,118 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func makeSparse(p *testpack, name string, size int64, data string, at int64) {
+	file, err := os.Create(p.fs.path(name))
+	p.assert.NoError(err)
+	defer file.Close()
+
+	_, err = file.WriteAt([]byte(data), at)
+	p.assert.NoError(err)
+	p.assert.NoError(file.Truncate(size))
+}
+
+func allocatedBlocks(p *testpack, name string) int64 {
+	st, err := os.Stat(p.fs.path(name))
+	p.assert.NoError(err)
+	return st.Sys().(*syscall.Stat_t).Blocks
+}
+
+func Test_Sparse(t *testing.T) {
+	const size = 4 * 1024 * 1024
+	const at = 1024 * 1024
+
+	t.Run("typical", run(func(p *testpack) {
+		makeSparse(p, testFile1, size, testContent1, at)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sparse": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(p.fs.file(testFile1).read(), p.fs.file(testFile2).read())
+		p.assert.LessOrEqual(allocatedBlocks(p, testFile2), allocatedBlocks(p, testFile1))
+	}))
+
+	t.Run("overwrite", run(func(p *testpack) {
+		makeSparse(p, testFile1, size, testContent1, at)
+		p.fs.file(testFile2).write(strings.Repeat("x", size+10))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sparse": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(p.fs.file(testFile1).read(), p.fs.file(testFile2).read())
+	}))
+
+	t.Run("only hole", run(func(p *testpack) {
+		makeSparse(p, testFile1, size, "", 0)
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sparse": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(strings.Repeat("\x00", size), p.fs.file(testFile2).read())
+	}))
+
+	t.Run("dense", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sparse": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testLongContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("empty", run(func(p *testpack) {
+		p.fs.file(testFile1).write("")
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sparse": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("", p.fs.file(testFile2).read())
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		makeSparse(p, testFile1, size, testContent1, at)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sparse": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(p.fs.file(testFile1).read(), p.fs.file(testFile2).read())
+	}))
+}
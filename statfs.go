@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errUnsupported = errors.New("unsupported on this platform")
+
+type statfsResult struct {
+	Free  uint64 `json:"free"`  // Bytes available to unprivileged users.
+	Total uint64 `json:"total"` // Bytes of the whole filesystem.
+}
+
+func (s *session) statfs(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("statfs took %s", time.Since(start))
+	}()
+
+	res, err := diskSpace(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func diskSpace(path string) (*statfsResult, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+
+	return &statfsResult{
+		Free:  st.Bavail * uint64(st.Bsize),
+		Total: st.Blocks * uint64(st.Bsize),
+	}, nil
+}
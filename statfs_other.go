@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func diskSpace(path string) (*statfsResult, error) {
+	return nil, errUnsupported
+}
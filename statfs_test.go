@@ -0,0 +1,32 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_Statfs(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "statfs": true}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+
+		st := &statfsResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), st))
+		p.assert.Less(uint64(0), st.Total)
+		p.assert.LessOrEqual(st.Free, st.Total)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "statfs": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
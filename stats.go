@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+)
+
+// counters holds daemon-wide statistics shared by all sessions.
+type counters struct {
+	speculationUsed   atomic.Int64
+	speculationWasted atomic.Int64
+	blockedRequests   atomic.Int64
+	busyResponses     atomic.Int64
+
+	// contentMemoryWait is the total nanoseconds tasks waited for
+	// --max-content-memory.
+	contentMemoryWait atomic.Int64
+
+	// inFlight is a gauge of running tasks and pending speculative opens.
+	inFlight atomic.Int64
+}
+
+var metrics = &counters{}
+
+type statsResult struct {
+	SpeculationUsed      int64   `json:"speculation_used"`
+	SpeculationWasted    int64   `json:"speculation_wasted"`
+	SpeculationUsedRatio float64 `json:"speculation_used_ratio"`
+	BlockedRequests      int64   `json:"blocked_requests"`
+	BusyResponses        int64   `json:"busy_responses"`
+	InFlight             int64   `json:"in_flight"`
+	ContentMemoryWaitMS  int64   `json:"content_memory_wait_ms"`
+}
+
+func (c *counters) snapshot() *statsResult {
+	used := c.speculationUsed.Load()
+	wasted := c.speculationWasted.Load()
+
+	var ratio float64
+	if used+wasted != 0 {
+		ratio = float64(used) / float64(used+wasted)
+	}
+
+	return &statsResult{
+		SpeculationUsed:      used,
+		SpeculationWasted:    wasted,
+		SpeculationUsedRatio: ratio,
+		BlockedRequests:      c.blockedRequests.Load(),
+		BusyResponses:        c.busyResponses.Load(),
+		InFlight:             c.inFlight.Load(),
+		ContentMemoryWaitMS:  time.Duration(c.contentMemoryWait.Load()).Milliseconds(),
+	}
+}
+
+func (s *session) stats() (string, error) {
+	j, err := json.Marshal(metrics.snapshot())
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
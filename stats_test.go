@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func statsOf(p *testpack) *statsResult {
+	res, err := p.sess.addTask([]byte(`{"stats": true}`))
+	p.assert.NoError(err)
+
+	st := &statsResult{}
+	p.assert.NoError(json.Unmarshal([]byte(res), st))
+	return st
+}
+
+func Test_Stats(t *testing.T) {
+	t.Run("used speculation", run(func(p *testpack) {
+		before := statsOf(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		p.sess.finalize()
+
+		after := statsOf(p)
+		p.assert.Equal(before.SpeculationUsed+1, after.SpeculationUsed)
+		p.assert.Equal(before.SpeculationWasted, after.SpeculationWasted)
+	}))
+
+	t.Run("wasted speculation", run(func(p *testpack) {
+		before := statsOf(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.finalize()
+
+		after := statsOf(p)
+		p.assert.Equal(before.SpeculationUsed, after.SpeculationUsed)
+		p.assert.Equal(before.SpeculationWasted+1, after.SpeculationWasted)
+	}))
+}
+
+func Test_Busy(t *testing.T) {
+	t.Run("over threshold", run(func(p *testpack) {
+		p.sess.cfg.maxInFlight = 2
+		before := statsOf(p)
+
+		metrics.inFlight.Add(2)
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		metrics.inFlight.Add(-2)
+
+		p.assert.NoError(err)
+		p.assert.Equal("busy", res)
+		p.assert.False(p.fs.file(testFile1).exists())
+
+		after := statsOf(p)
+		p.assert.Equal(before.BusyResponses+1, after.BusyResponses)
+	}))
+
+	t.Run("under threshold", run(func(p *testpack) {
+		p.sess.cfg.maxInFlight = 2
+
+		metrics.inFlight.Add(1)
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		metrics.inFlight.Add(-1)
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("unlimited", run(func(p *testpack) {
+		metrics.inFlight.Add(1000)
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		metrics.inFlight.Add(-1000)
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+}
+
+func Test_Busy_Speculate(t *testing.T) {
+	t.Run("gauge drains", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.finalize()
+
+		// Both the speculative open and the stats task itself have finished.
+		p.assert.Equal(int64(0), metrics.inFlight.Load())
+	}))
+}
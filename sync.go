@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type syncResult struct {
+	Copied    int `json:"copied"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+	Skipped   int `json:"skipped"` // Neither a regular file nor a directory.
+}
+
+// skipFunc tells walkRelative to leave out the entry at the path relative to
+// the root, along with its descendants if it's a directory.
+type skipFunc func(rel string, fi os.FileInfo) bool
+
+// walkRelative lists the entries under root keyed by the path relative to it.
+// The root itself is keyed by an empty string and never skipped. Skipped
+// directories aren't descended, so their descendants are neither stat-ed nor
+// counted in the returned number of skipped entries. skip may be nil.
+func walkRelative(root string, tree *dirTree, skip skipFunc) (map[string]os.FileInfo, int, error) {
+	mux := &sync.Mutex{}
+	entries := map[string]os.FileInfo{}
+	skipped := 0
+
+	err := concurrentWalk(root, tree, func(path string, fi os.FileInfo) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+		skipping := rel != "" && skip != nil && skip(rel, fi)
+
+		mux.Lock()
+		defer mux.Unlock()
+
+		if !skipping {
+			entries[rel] = fi
+			return nil
+		}
+
+		skipped++
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, skipped, nil
+}
+
+func sortedKeys(entries map[string]os.FileInfo) []string {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func joinRelative(root, rel string) string {
+	if rel == "" {
+		return root
+	}
+
+	return root + "/" + rel
+}
+
+// walkSource walks the src directory of a copy of a tree to dest.
+func (s *session) walkSource(srcPath, destPath string, skip skipFunc) (map[string]os.FileInfo, int, error) {
+	if isUnder(srcPath, destPath) || isUnder(destPath, srcPath) {
+		return nil, 0, fmt.Errorf("src and dest must not contain each other: %s, %s", srcPath, destPath)
+	}
+
+	srcTree, exists := s.walkRoot(srcPath)
+	if !exists {
+		return nil, 0, fmt.Errorf("no such directory: %s", srcPath)
+	}
+
+	entries, skipped, err := walkRelative(srcPath, srcTree, skip)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !entries[""].IsDir() {
+		return nil, 0, fmt.Errorf("not a directory: %s", srcPath)
+	}
+
+	return entries, skipped, nil
+}
+
+// copyEntries copies the entries walked under srcPath to destPath, parents
+// first. Directories and files get the permissions of their sources, and
+// files also get the mtime; files are written with opts otherwise. Entries
+// neither regular files nor directories are skipped with a warning, and ones
+// leave reports true for are left alone. leave may be nil.
+func (s *session) copyEntries(
+	srcPath, destPath string,
+	entries map[string]os.FileInfo,
+	opts *writeOptions,
+	leave func(rel string, fi os.FileInfo) (bool, error),
+) (copied, skipped int, err error) {
+	for _, rel := range sortedKeys(entries) {
+		fi := entries[rel]
+		path := joinRelative(destPath, rel)
+
+		if leave != nil {
+			left, err := leave(rel, fi)
+			if err != nil {
+				return copied, skipped, err
+			}
+			if left {
+				continue
+			}
+		}
+
+		switch {
+		case fi.IsDir():
+			if err := s.ensureDir(path, fi.Mode().Perm()); err != nil {
+				return copied, skipped, err
+			}
+
+		case fi.Mode().IsRegular():
+			perm := fi.Mode().Perm()
+			fileOpts := *opts
+			fileOpts.perm = &perm
+			fileOpts.overwrite = true
+
+			res, err := s.copyFile(joinRelative(srcPath, rel), path, &fileOpts)
+			if err != nil {
+				return copied, skipped, err
+			}
+
+			// Nothing was written, so the mtime is left as is.
+			if res != valTrue {
+				log.Warnf("skipping file not copied: %s: %s", joinRelative(srcPath, rel), res)
+				skipped++
+				continue
+			}
+
+			if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+				return copied, skipped, err
+			}
+			copied++
+
+		default:
+			log.Warnf("skipping unsupported file type: %s", joinRelative(srcPath, rel))
+			skipped++
+		}
+	}
+
+	return copied, skipped, nil
+}
+
+// syncDir mirrors src into dest, copying only files whose size or mtime
+// differ. Copied files get the mtime of the source so that the next sync can
+// skip them. Files absent from src are deleted if deleteExtraneous is set.
+func (s *session) syncDir(srcPath, destPath string, deleteExtraneous bool) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("syncDir took %s", time.Since(start))
+	}()
+
+	srcEntries, _, err := s.walkSource(srcPath, destPath, nil)
+	if err != nil {
+		return valFalse, err
+	}
+
+	destEntries := map[string]os.FileInfo{}
+	if s.existence(destPath) {
+		destTree, _ := s.walkRoot(destPath)
+		if destEntries, _, err = walkRelative(destPath, destTree, nil); err != nil {
+			return valFalse, err
+		}
+	}
+
+	res := &syncResult{}
+
+	res.Copied, res.Skipped, err = s.copyEntries(srcPath, destPath, srcEntries, &writeOptions{},
+		func(rel string, sfi os.FileInfo) (bool, error) {
+			path := joinRelative(destPath, rel)
+			dfi, ok := destEntries[rel]
+			if !ok {
+				return false, nil
+			}
+
+			switch {
+			case sfi.IsDir() && !dfi.IsDir():
+				_, err := s.delete(path, false, true)
+				return false, err
+
+			case sfi.IsDir():
+				return true, nil
+
+			case sfi.Mode().IsRegular() && dfi.IsDir():
+				_, err := s.delete(path, true, true)
+				return false, err
+
+			case sfi.Mode().IsRegular() && dfi.Size() == sfi.Size() && dfi.ModTime().Equal(sfi.ModTime()):
+				res.Unchanged++
+				return true, nil
+			}
+
+			return false, nil
+		})
+	if err != nil {
+		return valFalse, err
+	}
+
+	if deleteExtraneous {
+		// Descendants of a deleted directory are reported as not deleted.
+		for _, rel := range sortedKeys(destEntries) {
+			if _, ok := srcEntries[rel]; ok {
+				continue
+			}
+
+			deleted, err := s.delete(joinRelative(destPath, rel), true, true)
+			if err != nil {
+				return valFalse, err
+			}
+			if deleted {
+				res.Deleted++
+			}
+		}
+	}
+
+	j, err := json.Marshal(res)
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
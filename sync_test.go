@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Sync(t *testing.T) {
+	setup := func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1Dir2File1).write(testContent2)
+	}
+
+	syncTask := func(p *testpack, extra string) (string, error) {
+		return p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sync": true%s}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2),
+			extra))
+	}
+
+	t.Run("new destination", run(func(p *testpack) {
+		setup(p)
+
+		res, err := syncTask(p, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"copied":2,"unchanged":0,"deleted":0,"skipped":0}`, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir2+"/"+testFile1).read())
+		p.assert.Equal(testContent2, p.fs.file(testDir2+"/"+testDir2+"/"+testFile1).read())
+	}))
+
+	t.Run("unchanged files skipped", run(func(p *testpack) {
+		setup(p)
+		syncTask(p, "")
+
+		res, err := syncTask(p, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"copied":0,"unchanged":2,"deleted":0,"skipped":0}`, res)
+	}))
+
+	t.Run("modified file copied", run(func(p *testpack) {
+		setup(p)
+		syncTask(p, "")
+
+		p.fs.file(testDir1File1).write(testContent2)
+		later := time.Now().Add(time.Hour)
+		os.Chtimes(p.fs.path(testDir1File1), later, later)
+
+		res, err := syncTask(p, "")
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"copied":1,"unchanged":1,"deleted":0,"skipped":0}`, res)
+		p.assert.Equal(testContent2, p.fs.file(testDir2+"/"+testFile1).read())
+	}))
+
+	t.Run("delete extraneous", run(func(p *testpack) {
+		setup(p)
+		p.fs.dir(testDir2).create()
+		p.fs.file(testDir2 + "/" + testFile2).write(testContent1)
+		p.fs.dir(testDir2 + "/" + testDir1).create()
+		p.fs.file(testDir2 + "/" + testDir1 + "/" + testFile1).write(testContent1)
+
+		res, err := syncTask(p, `, "delete_extraneous": true`)
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"copied":2,"unchanged":0,"deleted":2,"skipped":0}`, res)
+		p.assert.Equal([]string{testDir2, testFile1}, p.fs.dir(testDir2).ls())
+	}))
+
+	t.Run("extraneous kept by default", run(func(p *testpack) {
+		setup(p)
+		p.fs.dir(testDir2).create()
+		p.fs.file(testDir2 + "/" + testFile2).write(testContent1)
+
+		_, err := syncTask(p, "")
+
+		p.assert.NoError(err)
+		p.assert.True(p.fs.file(testDir2 + "/" + testFile2).exists())
+	}))
+
+	t.Run("nested", run(func(p *testpack) {
+		setup(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sync": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir1Dir2)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Sync_Speculate(t *testing.T) {
+	t.Run("speculative destination file", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir2+"/"+testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir2+"/"+testFile2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "sync": true, "delete_extraneous": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testDir2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`{"copied":1,"unchanged":0,"deleted":0,"skipped":0}`, res)
+
+		p.sess.finalize()
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testDir2).ls())
+		p.assert.Equal(testContent1, p.fs.file(testDir2+"/"+testFile1).read())
+	}))
+}
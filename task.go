@@ -1,15 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"container/list"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,23 +28,131 @@ import (
 type content []byte
 
 type task struct {
-	Destination     string  `json:"dest"`
-	SourcePath      *string `json:"src"`
-	Content         content `json:"content_b64"` // Never use Content for a large file.
-	Permission      *uint32 `json:"perm"`        // "src", "content_b64", or "mkdir" is required.
-	Speculate       bool    `json:"speculate"`
-	Existence       bool    `json:"existence"`
-	Mkdir           bool    `json:"mkdir"`
-	ListDir         bool    `json:"listdir"`
-	Delete          bool    `json:"delete"`
-	DeleteRecursive bool    `json:"delete_recursive"`
+	Destination       string            `json:"dest"`
+	SourcePath        *string           `json:"src"`
+	Content           content           `json:"content_b64"` // Never use Content for a large file.
+	Permission        *uint32           `json:"perm"`        // "src", "content_b64", or "mkdir" is required.
+	Speculate         bool              `json:"speculate"`
+	SpeculateAlias    bool              `json:"speculative"` // Alias of "speculate" for a common typo.
+	WaitOpen          bool              `json:"wait_open"`   // "speculate" waits for the open to report its failure.
+	Existence         bool              `json:"existence"`
+	Mkdir             bool              `json:"mkdir"`
+	ListDir           bool              `json:"listdir"`
+	ListDirDirs       bool              `json:"listdir_dirs"`
+	ListDirFiles      bool              `json:"listdir_files"`
+	Sorted            bool              `json:"sorted"` // Sort listed entries lexicographically.
+	Delete            bool              `json:"delete"`
+	DeleteRecursive   bool              `json:"delete_recursive"`
+	Stats             bool              `json:"stats"`
+	ReadHead          *int              `json:"read_head"`
+	Touch             bool              `json:"touch"`
+	ExistenceMany     []string          `json:"existence_many"`
+	Into              bool              `json:"into"` // Place "src" inside the "dest" directory.
+	ChmodRecursive    bool              `json:"chmod_recursive"`
+	DirPermission     *uint32           `json:"dir_perm"`  // Overrides "perm" for directories, including ones created by "speculate".
+	FilePermission    *uint32           `json:"file_perm"` // Overrides "perm" for files.
+	ChownRecursive    bool              `json:"chown_recursive"`
+	UID               *int              `json:"uid"`
+	GID               *int              `json:"gid"`
+	BestEffort        bool              `json:"best_effort"` // Continue on failures of individual entries.
+	Statfs            bool              `json:"statfs"`
+	Mktemp            bool              `json:"mktemp"`
+	Prefix            string            `json:"prefix"`
+	Move              bool              `json:"move"`
+	SkipUnchanged     bool              `json:"skip_unchanged"` // Don't write if the content is identical.
+	VerifySHA256      *string           `json:"verify_sha256"`  // Expected hex digest of "dest".
+	IsMountpoint      bool              `json:"is_mountpoint"`
+	SameFSOnly        *bool             `json:"same_fs_only"` // Defaults to true for "delete_recursive".
+	Overwrite         *bool             `json:"overwrite"`    // Defaults to true for create and copy, false for "move" of directories.
+	FsyncDir          bool              `json:"fsync_dir"`
+	Atomic            bool              `json:"atomic"` // Never expose a partially written file on create.
+	EmptyDir          bool              `json:"empty_dir"`
+	Hello             bool              `json:"hello"`
+	Binary            *bool             `json:"binary"` // With "hello", turn the binary response mode on or off.
+	CancelSpeculation bool              `json:"cancel_speculation"`
+	CreateExclusive   bool              `json:"create_exclusive"` // Same as "overwrite": false.
+	Untar             content           `json:"untar_b64"`        // Tar archive extracted under "dest".
+	ReportChanged     bool              `json:"report_changed"`   // Costs an extra read of the old content.
+	FullMode          bool              `json:"full_mode"`        // Keep setuid, setgid, and sticky bits of "perm".
+	Sync              bool              `json:"sync"`             // Mirror the "src" directory into "dest".
+	DeleteExtraneous  bool              `json:"delete_extraneous"`
+	Open              *string           `json:"open"` // Path to open for appending via "handle".
+	Handle            *string           `json:"handle"`
+	Append            content           `json:"append_b64"`
+	CloseHandle       *string           `json:"close_handle"`
+	TreeHash          bool              `json:"tree_hash"`
+	ExpectSrcSHA256   *string           `json:"expect_src_sha256"` // Copy only if "src" has this hex digest.
+	DumpTree          bool              `json:"dump_tree"`         // Only with --debug.
+	Fallocate         *int64            `json:"fallocate"`         // Size in bytes to reserve for "dest".
+	Recursive         bool              `json:"recursive"`         // Also remove non-empty directories in "empty_dir".
+	Relink            bool              `json:"relink"`            // Atomically point the symbolic link "dest" to "src".
+	Force             bool              `json:"force"`             // Let "relink" replace a non-link "dest".
+	WaitSize          *int64            `json:"wait_size"`         // Bytes "dest" must reach within "timeout_ms".
+	TimeoutMS         *int64            `json:"timeout_ms"`
+	Walk              bool              `json:"walk"`          // List relative paths under "dest" recursively.
+	MaxDepth          *int              `json:"max_depth"`     // 1 lists only the direct children in "walk".
+	Pattern           *string           `json:"pattern"`       // Glob matched against base names in "walk".
+	AppendRotate      bool              `json:"append_rotate"` // Append "content_b64" and rotate "dest" beyond "max_bytes".
+	MaxBytes          *int64            `json:"max_bytes"`
+	Clone             bool              `json:"clone"`     // Reflink, hard link, or copy "src" to "dest", whichever works first.
+	MoveMany          []movePair        `json:"move_many"` // No ordering or atomicity across pairs.
+	Writable          bool              `json:"writable"`  // Probe "dest" directory with a temporary file.
+	BeginTx           bool              `json:"begin_tx"`  // Journal file writes until "commit_tx" or "rollback_tx".
+	CommitTx          bool              `json:"commit_tx"`
+	RollbackTx        bool              `json:"rollback_tx"`
+	ReadRange         bool              `json:"read_range"` // Read "length" bytes of "dest" from "offset".
+	Offset            *int64            `json:"offset"`
+	Length            *int              `json:"length"`
+	SetMtime          *int64            `json:"set_mtime"`      // Unix nanoseconds, alone or after a write.
+	SetAtime          *int64            `json:"set_atime"`      // Unix nanoseconds, alone or after a write.
+	ListDirStream     bool              `json:"listdir_stream"` // Send entries in lines of "chunk_size" until "[]".
+	ChunkSize         *int              `json:"chunk_size"`
+	CopyReplace       bool              `json:"copy_replace"` // Copy a small text "src" with "replacements" applied.
+	Replacements      map[string]string `json:"replacements"`
+	MakeParents       *bool             `json:"make_parents"`        // Create missing parents of "dest" on create and copy; defaults to --make-parents.
+	PruneEmptyParents bool              `json:"prune_empty_parents"` // Remove ancestors left empty by "delete".
+	StopAt            *string           `json:"stop_at"`             // Never prune this directory or above.
+	EnsurePerm        bool              `json:"ensure_perm"`         // Chmod "dest" to "perm" only if it differs.
+	ContentJSON       json.RawMessage   `json:"content_json"`        // Validated JSON value always written atomically.
+	Sparse            bool              `json:"sparse"`              // Keep holes of "src" on copy where the OS can find them.
+	Admin             *string           `json:"admin"`               // Operator command such as "set_root" with --admin-enabled.
+	Value             *string           `json:"value"`
+	StoreSizeXattr    bool              `json:"store_size_xattr"` // Set "user.content_length" on create and copy (Linux only).
+	CleanTemp         bool              `json:"clean_temp"`       // Delete files in "dest" matching "pattern" older than "older_than_ms".
+	OlderThanMS       *int64            `json:"older_than_ms"`
+	Inode             bool              `json:"inode"`                   // Report the device and inode numbers of "dest".
+	VerifyAfterWrite  bool              `json:"verify_after_write"`      // Read "dest" back after create or copy; false if it differs.
+	MkdirMany         []mkdirEntry      `json:"mkdir_many"`              // Parents first, then concurrently per depth.
+	ModifiedSince     *int64            `json:"modified_since"`          // Unix nanoseconds; "listdir" and "walk" list only entries modified later.
+	CloneMeta         bool              `json:"clone_meta"`              // Copy the mode, owner, and group of "src" to "dest".
+	InRoot            bool              `json:"in_root"`                 // Whether "dest" resolves under --root and is allowed.
+	TrailingNewline   bool              `json:"ensure_trailing_newline"` // Append "\n" to "content_b64" unless it ends with one.
+	Realpath          bool              `json:"realpath"`                // Resolve symbolic links in "dest".
+	Backup            bool              `json:"backup"`                  // Keep the replaced "dest" of move or copy as "dest~" and return its path.
+	Nlink             bool              `json:"nlink"`                   // Report the number of hard links to "dest".
+	NormalizeEOL      *string           `json:"normalize_eol"`           // "lf" or "crlf"; copy a small text "src" with line endings rewritten.
+	Populate          fileContents      `json:"populate"`                // File names to contents written in the "dest" directory.
+	FirstExisting     []string          `json:"first_existing"`          // Return the first existing path, directories included, or null.
+	Zero              bool              `json:"zero"`                    // Truncate "dest" to zero bytes, keeping its inode.
+	CopyXattrs        bool              `json:"copy_xattrs"`             // Replicate extended attributes of "src" on copy (Linux only).
+	ListSpeculations  bool              `json:"list_speculations"`       // Paths of live speculative files and whether they're new.
+	Cas               bool              `json:"cas"`                     // Write "content_b64" only if "dest" has "expected_b64"; "mismatch" otherwise.
+	Expected          content           `json:"expected_b64"`            // Empty matches a missing file in "cas".
+	Fsync             bool              `json:"fsync"`                   // Sync "dest" on create and copy, failing on a deferred write error.
+	CopyRecursive     bool              `json:"copy_recursive"`          // Copy the "src" directory into "dest" without deleting anything.
+	Include           []string          `json:"include"`                 // Globs of files "copy_recursive" copies; all if empty.
+	Exclude           []string          `json:"exclude"`                 // Globs of files and pruned directories "copy_recursive" skips.
+	MaxFileBytes      *int64            `json:"max_file_bytes"`          // "copy_recursive" skips larger files.
 }
 
 type speculativeFile struct {
-	// name   string
-	parent *dirTree
-	file   *futureFile
-	done   <-chan *futureFile
+	name    string
+	parent  *dirTree
+	file    *futureFile   // Available after done is closed.
+	done    chan struct{} // Closed when the speculative open finishes.
+	lru     *list.Element
+	claimed atomic.Bool
+	pooled  bool
 }
 
 type futureFile struct {
@@ -46,26 +163,70 @@ type futureFile struct {
 }
 
 const (
-	valFalse   = "false"
-	valTrue    = "true"
-	valInvalid = "null"
+	valFalse     = "false"
+	valTrue      = "true"
+	valInvalid   = "null"
+	valUnchanged = "unchanged"
+	valExists    = "exists"
+	valMismatch  = "mismatch"
+	valBusy      = "busy"
 )
 
 func (f *speculativeFile) getFutureFile() *futureFile {
-	if f.file == nil {
-		f.file = <-f.done
+	<-f.done
+	return f.file
+}
+
+// waitFutureFile is getFutureFile that gives up on cancellation,
+// such as when the open is stuck on a hung mount.
+func (f *speculativeFile) waitFutureFile(ctx context.Context) (*futureFile, error) {
+	select {
+	case <-f.done:
+		return f.file, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
-	return f.file
+// claim reports whether the caller is the first to take over the file.
+func (f *speculativeFile) claim() bool {
+	return f.claimed.CompareAndSwap(false, true)
+}
+
+// withdraw keeps other sessions from claiming the file so that its owner can
+// change it, and reports false if one has already claimed it.
+func (f *speculativeFile) withdraw() bool {
+	if f.pooled {
+		sharedSpeculations.remove(f.getPath(), f)
+	}
+
+	return !f.claimed.Load()
+}
+
+func (f *speculativeFile) getPath() string {
+	return f.parent.getPath() + "/" + f.name
 }
 
 func (f *speculativeFile) disposeUnused() error {
+	// Another session has already claimed this file.
+	if !f.claim() {
+		return nil
+	}
+
+	if f.pooled {
+		sharedSpeculations.remove(f.getPath(), f)
+	}
+
 	fut := f.getFutureFile()
+	metrics.speculationWasted.Add(1)
+
 	if fut.err != nil {
 		log.Error(fut.err)
 		return nil
 	}
 
+	log.Debugf("speculation wasted: %s", fut.file.Name())
+
 	if fut.isNew {
 		if err := os.Remove(fut.file.Name()); err != nil {
 			return err
@@ -82,25 +243,51 @@ type dirTree struct {
 	parent      *dirTree
 	speculative bool
 	pathCache   *string
+	limits      *treeLimits // Shared by the whole tree.
 }
 
 func newDirTree(name string, parent *dirTree, speculative bool) *dirTree {
+	var limits *treeLimits
+	if parent != nil {
+		limits = parent.limits
+	}
+
 	return &dirTree{
 		childDirs:   map[string]*dirTree{},
 		childFiles:  map[string]*speculativeFile{},
 		name:        name,
 		parent:      parent,
 		speculative: speculative,
+		limits:      limits,
 	}
 }
 
-func createDirTree(parent *dirTree, name string, speculate bool) (*dirTree, error) {
+// createDirTree creates the directory unless it exists. perm is applied only
+// to a newly created directory, and nil means 0755 subject to umask.
+func createDirTree(parent *dirTree, name string, speculate bool, perm *os.FileMode) (*dirTree, error) {
 	path := parent.getPath() + "/" + name
 	stat, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot create directory: %w", err)
+	}
+
 	if err != nil {
-		if err := os.Mkdir(path, 0755); err != nil {
+		newPerm := os.FileMode(0755)
+		if perm != nil {
+			newPerm = *perm
+		}
+
+		if err := os.Mkdir(path, newPerm); err != nil {
 			return nil, err
 		}
+
+		// Mkdir is subject to umask.
+		if perm != nil {
+			if err := os.Chmod(path, *perm); err != nil {
+				return nil, err
+			}
+		}
+
 		return newDirTree(name, parent, speculate), nil
 	}
 
@@ -108,84 +295,90 @@ func createDirTree(parent *dirTree, name string, speculate bool) (*dirTree, erro
 		return newDirTree(name, parent, false), nil
 	}
 
+	// Wrapping ENOTDIR tells a path that assumes a directory in place of a
+	// file apart from permission problems.
 	return nil, fmt.Errorf(
-		"cannot create directory: file already exists: %s", path)
+		"cannot create directory: a non-directory file exists at %s: %w", path, syscall.ENOTDIR)
 }
 
 func (t *dirTree) speculateFile(name string, perm *os.FileMode) *speculativeFile {
 	path := t.getPath() + "/" + name
-	done := make(chan *futureFile)
+	done := make(chan struct{})
 
-	t.childFiles[name] = &speculativeFile{
+	file := &speculativeFile{
+		name:   name,
 		done:   done,
 		parent: t,
 	}
+	t.childFiles[name] = file
 
+	metrics.inFlight.Add(1)
 	go func() {
 		defer close(done)
+		defer metrics.inFlight.Add(-1)
+		t.limits.acquireSpeculate()
+		defer t.limits.releaseSpeculate()
+		file.file = openSpeculatively(path, perm)
+	}()
 
-		permission := func(file *os.File) (os.FileMode, error) {
-			st, err := file.Stat()
-			if err != nil {
-				return 00, err
-			}
+	return file
+}
 
-			return st.Mode().Perm(), nil
+func openSpeculatively(path string, perm *os.FileMode) *futureFile {
+	permission := func(file *os.File) (os.FileMode, error) {
+		st, err := file.Stat()
+		if err != nil {
+			return 00, err
 		}
 
-		if file, err := os.OpenFile(path, os.O_WRONLY, 0666); err == nil {
-			curPerm, err := permission(file)
-			if err != nil {
-				done <- &futureFile{err: err}
-				return
-			}
-
-			// Never change permission in advance since it reflects immediately.
+		return modeBits(st.Mode()), nil
+	}
 
-			done <- &futureFile{
-				file:  file,
-				isNew: false,
-				perm:  curPerm,
-			}
-			return
+	if file, err := os.OpenFile(path, os.O_WRONLY, 0666); err == nil {
+		curPerm, err := permission(file)
+		if err != nil {
+			return &futureFile{err: err}
 		}
 
-		var newPerm os.FileMode
-		if perm != nil {
-			newPerm = *perm
-		} else {
-			newPerm = 0666
-		}
+		// Never change permission in advance since it reflects immediately.
 
-		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, newPerm)
-		if err != nil {
-			done <- &futureFile{err: err}
-			return
+		return &futureFile{
+			file:  file,
+			isNew: false,
+			perm:  curPerm,
 		}
+	}
 
-		createdPerm, err := permission(file)
-		if err != nil {
-			done <- &futureFile{err: err}
-			return
-		}
+	var newPerm os.FileMode
+	if perm != nil {
+		newPerm = *perm
+	} else {
+		newPerm = 0666
+	}
 
-		if perm != nil && createdPerm != *perm {
-			if err := file.Chmod(*perm); err != nil {
-				done <- &futureFile{err: err}
-				return
-			}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, newPerm)
+	if err != nil {
+		return &futureFile{err: err}
+	}
 
-			createdPerm = *perm
-		}
+	createdPerm, err := permission(file)
+	if err != nil {
+		return &futureFile{err: err}
+	}
 
-		done <- &futureFile{
-			file:  file,
-			isNew: true,
-			perm:  createdPerm,
+	if perm != nil && !permEqual(createdPerm, *perm) {
+		if err := file.Chmod(*perm); err != nil {
+			return &futureFile{err: err}
 		}
-	}()
 
-	return t.childFiles[name]
+		createdPerm = *perm
+	}
+
+	return &futureFile{
+		file:  file,
+		isNew: true,
+		perm:  createdPerm,
+	}
 }
 
 // getPath returns the dir path without a trailing slash.
@@ -204,13 +397,29 @@ func (t *dirTree) getPath() string {
 	return *t.pathCache
 }
 
-func (t *dirTree) addFileInternal(pathParts []string, perm *os.FileMode) (*speculativeFile, error) {
+// file returns the speculative file of the child. A file another session has
+// claimed from the pool is no longer this session's, so it's dropped instead.
+func (t *dirTree) file(name string) (*speculativeFile, bool) {
+	f, ok := t.childFiles[name]
+	if !ok {
+		return nil, false
+	}
+
+	if f.claimed.Load() {
+		delete(t.childFiles, name)
+		return nil, false
+	}
+
+	return f, true
+}
+
+func (t *dirTree) addFileInternal(pathParts []string, perm, dirPerm *os.FileMode) (*speculativeFile, error) {
 	if len(pathParts) < 1 {
 		log.Panicf("pathParts must contain at least one element")
 	}
 
 	if len(pathParts) == 1 {
-		file, ok := t.childFiles[pathParts[0]]
+		file, ok := t.file(pathParts[0])
 		if !ok {
 			return t.speculateFile(pathParts[0], perm), nil
 		}
@@ -220,7 +429,7 @@ func (t *dirTree) addFileInternal(pathParts []string, perm *os.FileMode) (*specu
 	dir, ok := t.childDirs[pathParts[0]]
 	if !ok {
 		var err error
-		dir, err = createDirTree(t, pathParts[0], true)
+		dir, err = createDirTree(t, pathParts[0], true, dirPerm)
 		if err != nil {
 			return nil, err
 		}
@@ -228,7 +437,7 @@ func (t *dirTree) addFileInternal(pathParts []string, perm *os.FileMode) (*specu
 		t.childDirs[pathParts[0]] = dir
 	}
 
-	return dir.addFileInternal(pathParts[1:], perm)
+	return dir.addFileInternal(pathParts[1:], perm, dirPerm)
 }
 
 func (t *dirTree) mkDirInternal(dirParts []string, perm *os.FileMode) error {
@@ -242,33 +451,7 @@ func (t *dirTree) mkDirInternal(dirParts []string, perm *os.FileMode) error {
 
 	dir, ok := t.childDirs[dirParts[0]]
 	if !ok {
-		path := t.getPath() + "/" + filepath.Join(strings.Join(dirParts, "/"))
-
-		var newPerm os.FileMode
-		if perm == nil {
-			newPerm = 0755
-		} else {
-			newPerm = *perm
-		}
-
-		if err := os.Mkdir(path, newPerm); err != nil {
-			return err
-		}
-
-		if perm == nil {
-			return nil
-		}
-
-		st, err := os.Stat(path)
-		if err != nil {
-			return err
-		}
-
-		if *perm == st.Mode().Perm() {
-			return nil
-		}
-
-		return os.Chmod(path, *perm)
+		return mkdirOnDisk(t.getPath()+"/"+filepath.Join(strings.Join(dirParts, "/")), perm)
 	}
 
 	if len(dirParts) == 1 {
@@ -282,8 +465,42 @@ func (t *dirTree) mkDirInternal(dirParts []string, perm *os.FileMode) error {
 	return dir.mkDirInternal(dirParts[1:], perm)
 }
 
+// mkdirOnDisk creates a directory the speculative tree knows nothing about.
+// perm is exactly applied, and nil means 0755 subject to umask.
+func mkdirOnDisk(path string, perm *os.FileMode) error {
+	var newPerm os.FileMode
+	if perm == nil {
+		newPerm = 0755
+	} else {
+		newPerm = *perm
+	}
+
+	if err := os.Mkdir(path, newPerm); err != nil {
+		return err
+	}
+
+	if perm == nil {
+		return nil
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if permEqual(st.Mode(), *perm) {
+		return nil
+	}
+
+	return os.Chmod(path, *perm)
+}
+
 func (t *dirTree) clean() error {
+	// Cache the path before child goroutines read it.
+	path := t.getPath()
+
 	eg := &errgroup.Group{}
+	eg.SetLimit(t.limits.cleanLimit())
 
 	for _, f := range t.childFiles {
 		eg.Go(f.disposeUnused)
@@ -304,8 +521,6 @@ func (t *dirTree) clean() error {
 		return nil
 	}
 
-	path := t.getPath()
-
 	dir, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -325,14 +540,20 @@ func (t *dirTree) clean() error {
 	return nil
 }
 
-func (t *dirTree) done() {
+func (t *dirTree) done(ctx context.Context) error {
 	for _, f := range t.childFiles {
-		f.getFutureFile()
+		if _, err := f.waitFutureFile(ctx); err != nil {
+			return err
+		}
 	}
 
 	for _, d := range t.childDirs {
-		d.done()
+		if err := d.done(ctx); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 func (t *dirTree) findDirInternal(dirParts []string) *dirTree {
@@ -352,20 +573,20 @@ func (t *dirTree) findDirInternal(dirParts []string) *dirTree {
 	return dir.findDirInternal(dirParts[1:])
 }
 
-func (t *dirTree) useSpeculativeFile(pathParts []string) *futureFile {
+func (t *dirTree) useSpeculativeFile(pathParts []string) *speculativeFile {
 	if len(pathParts) < 1 {
 		log.Panicf("pathParts must contain at least one element")
 	}
 
 	if len(pathParts) == 1 {
-		file, ok := t.childFiles[pathParts[0]]
+		file, ok := t.file(pathParts[0])
 		if !ok {
 			return nil
 		}
-		fut := file.getFutureFile()
+		file.getFutureFile()
 		delete(t.childFiles, pathParts[0])
 		t.speculative = false
-		return fut
+		return file
 	}
 
 	dir, ok := t.childDirs[pathParts[0]]
@@ -373,9 +594,12 @@ func (t *dirTree) useSpeculativeFile(pathParts []string) *futureFile {
 		return nil
 	}
 
-	fut := dir.useSpeculativeFile(pathParts[1:])
+	file := dir.useSpeculativeFile(pathParts[1:])
+	if file == nil {
+		return nil
+	}
 	t.speculative = false
-	return fut
+	return file
 }
 
 func (t *dirTree) logicalList() ([]string, error) {
@@ -392,33 +616,46 @@ func (t *dirTree) logicalList() ([]string, error) {
 
 	entries := make([]string, 0, len(names))
 	for _, n := range names {
-		if d, ok := t.childDirs[n]; ok {
-			if d.speculative {
-				continue
-			}
+		if t.logicallyExists(n) {
 			entries = append(entries, n)
-			continue
 		}
+	}
 
-		if f, ok := t.childFiles[n]; ok {
-			if f.getFutureFile().isNew {
-				continue
-			}
-			entries = append(entries, n)
-			continue
-		}
+	return entries, nil
+}
 
-		entries = append(entries, n)
+// logicallyExists reports whether the physically existing child should be
+// regarded as existent.
+func (t *dirTree) logicallyExists(name string) bool {
+	if d, ok := t.childDirs[name]; ok {
+		return !d.speculative
 	}
 
-	return entries, nil
+	if f, ok := t.file(name); ok {
+		return !f.getFutureFile().isNew
+	}
+
+	return true
 }
 
-func (t *dirTree) delete(recursive bool) (bool, error) {
+// delete removes the directory logically.
+// If dev is not nil, entries on other devices are never removed.
+func (t *dirTree) delete(recursive bool, dev *uint64) (bool, error) {
 	if t.speculative {
 		return false, nil
 	}
 
+	if dev != nil {
+		fi, err := os.Stat(t.getPath())
+		if err != nil {
+			return false, err
+		}
+
+		if err := checkDevice(t.getPath(), fi, dev); err != nil {
+			return false, err
+		}
+	}
+
 	names, err := t.logicalList()
 	if err != nil {
 		return false, err
@@ -436,9 +673,12 @@ func (t *dirTree) delete(recursive bool) (bool, error) {
 	eg := &errgroup.Group{}
 	for _, n := range names {
 		n := n
+		// Withdrawn here since the tree is changed only by this goroutine.
+		f, ok := t.file(n)
+		speculated := ok && f.withdraw()
 		eg.Go(func() error {
 			if d, ok := t.childDirs[n]; ok {
-				succeeded, err := d.delete(true)
+				succeeded, err := d.delete(true, dev)
 				if err != nil {
 					return err
 				}
@@ -450,12 +690,12 @@ func (t *dirTree) delete(recursive bool) (bool, error) {
 				return nil
 			}
 
-			if f, ok := t.childFiles[n]; ok {
+			if speculated {
 				f.getFutureFile().isNew = true
 				return nil
 			}
 
-			return concurrentRemove(t.getPath()+"/"+n, true)
+			return concurrentRemove(t.getPath()+"/"+n, true, dev)
 		})
 	}
 
@@ -468,13 +708,23 @@ func (t *dirTree) delete(recursive bool) (bool, error) {
 }
 
 type session struct {
+	cfg                *config
 	wg                 *sync.WaitGroup
 	finalizeMux        *sync.Mutex
 	finalized          bool
 	speculativeDirTree *dirTree
+	speculations       *list.List // Live speculative files, oldest first.
+	openFiles          map[string]*os.File
+	handles            map[string]*os.File // Files opened by "open", keyed by token.
+	ctx                context.Context     // Cancelled when the connection ends.
+	tx                 *transaction        // Non-nil between "begin_tx" and its end.
+	emit               func([]byte) error  // Sends a line ahead of the response, if streaming is possible.
+	binary             bool                // Responses are bytes and frames instead of lines.
+	dirCache           *dirCache           // Nil unless --dir-cache-size is set.
 }
 
-const copyBufferSize = 64 * 1024
+// maxReadHeadBytes caps the size requested by read_head and read_range.
+const maxReadHeadBytes = 64 * 1024
 
 func (c *content) UnmarshalJSON(data []byte) error {
 	var s string
@@ -491,12 +741,21 @@ func (c *content) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func newSession() *session {
+func newSession(cfg *config) *session {
+	tree := newDirTree("", nil, false)
+	tree.limits = newTreeLimits(cfg)
+
 	return &session{
+		cfg:                cfg,
 		wg:                 &sync.WaitGroup{},
 		finalizeMux:        &sync.Mutex{},
 		finalized:          false,
-		speculativeDirTree: newDirTree("", nil, false),
+		speculativeDirTree: tree,
+		speculations:       list.New(),
+		openFiles:          map[string]*os.File{},
+		handles:            map[string]*os.File{},
+		ctx:                context.Background(),
+		dirCache:           newDirCache(cfg.dirCacheSize),
 	}
 }
 
@@ -506,305 +765,1601 @@ func (s *session) addTask(input []byte) (string, error) {
 		log.Debugf("addTask took %s", time.Since(start))
 	}()
 
-	var task task
-	if err := json.Unmarshal(input, &task); err != nil {
-		return valInvalid, err
+	// Pick up a config replaced by an admin task or a reload. A running task
+	// keeps the config it started with.
+	if cfg := liveConfig.Load(); cfg != nil {
+		s.cfg = cfg
 	}
 
-	normalizePath := func(path string) (string, error) {
-		start := time.Now()
-		defer func() {
-			log.Debugf("normalizePath took %s", time.Since(start))
-		}()
+	// Push back rather than pile more onto an overloaded mount.
+	if s.overloaded() {
+		metrics.busyResponses.Add(1)
+		return valBusy, nil
+	}
 
-		// There's an assumption that no symbolic link exists.
-		return filepath.Abs(path)
+	metrics.inFlight.Add(1)
+	defer metrics.inFlight.Add(-1)
+
+	return s.handleTask(input)
+}
+
+// handleTask runs an admitted request line, which is either a task or a
+// batch of them. The tasks of a batch come here without being admitted again.
+func (s *session) handleTask(input []byte) (string, error) {
+	if isBatch(input) {
+		return s.addBatch(input)
 	}
 
-	destPath, err := normalizePath(task.Destination)
+	// Each task of a batch reserves for itself, so never reserve for a batch.
+	release, err := contentBudget.reserve(s.ctx, int64(len(input)))
 	if err != nil {
-		return valInvalid, err
+		return valFalse, err
 	}
+	defer release()
 
-	var perm *os.FileMode
-	if task.Permission != nil {
-		p := os.FileMode(*task.Permission).Perm()
-		perm = &p
+	res, err := s.runTask(input)
+	if err != nil && s.cfg.verbose && (res == valFalse || res == valInvalid) {
+		return s.errorResponse(res, err)
 	}
 
-	if task.SourcePath != nil {
-		return s.copyFile(*task.SourcePath, destPath, perm)
-	}
+	return res, err
+}
 
-	if task.Content != nil {
-		return s.createFile(task.Content, destPath, perm)
+// overloaded reports whether in-flight operations reached --max-in-flight.
+func (s *session) overloaded() bool {
+	return 0 < s.cfg.maxInFlight && s.cfg.maxInFlight <= metrics.inFlight.Load()
+}
+
+// runTask parses and executes a single task.
+func (s *session) runTask(input []byte) (string, error) {
+	task, err := s.parseTask(input)
+	if err != nil {
+		return valInvalid, err
 	}
 
-	if task.Speculate {
-		if err := s.speculateFile(destPath, perm); err != nil {
-			return valTrue, err
-		}
+	s.invalidateDirCache(task)
 
-		return valTrue, nil
+	if task.BeginTx {
+		return s.beginTx()
 	}
 
-	if task.Existence {
-		if s.existence(destPath) {
-			return valTrue, nil
-		}
-		return valFalse, nil
+	if task.CommitTx {
+		return s.commitTx()
 	}
 
-	if task.Mkdir {
-		if err := s.mkdir(destPath, perm); err != nil {
-			return valFalse, err
-		}
-		return valTrue, err
+	if task.RollbackTx {
+		return s.rollbackTx()
 	}
 
-	if task.ListDir {
-		files, err := s.listDir(destPath)
-		if err != nil {
-			return "[]", err
-		}
-
-		j, err := json.Marshal(files)
-		if err != nil {
-			return "[]", err
-		}
-
-		return string(j), nil
+	if s.tx != nil {
+		return s.execTaskInTx(task)
 	}
 
-	if task.Delete {
-		succeeded, err := s.deleteSingle(destPath)
-		var res string
-		if succeeded {
-			res = valTrue
-		} else {
-			res = valFalse
-		}
+	return s.execTask(task)
+}
 
+// execTask executes a parsed task, and then applies "set_mtime" and
+// "set_atime" if the task has written dest or has nothing else to do.
+func (s *session) execTask(task *task) (string, error) {
+	res, err := s.dispatchTask(task)
+	if task.SetMtime == nil && task.SetAtime == nil {
 		return res, err
 	}
 
-	if task.DeleteRecursive {
-		succeeded, err := s.deleteRecursive(destPath)
-		var res string
-		if succeeded {
-			res = valTrue
-		} else {
-			res = valFalse
+	if !errors.Is(err, errNeedMoreParameters) {
+		if err != nil {
+			return res, err
 		}
 
-		return res, err
+		switch res {
+		case valTrue, valChanged, valUnchanged:
+		default:
+			return res, err
+		}
 	}
 
-	return valInvalid, fmt.Errorf("need more parameters")
-}
-
-func (s *session) deleteRecursive(path string) (bool, error) {
-	start := time.Now()
-	defer func() {
-		log.Debugf("deleteRecursive took %s", time.Since(start))
-	}()
+	destPath, err := s.normalizePath(task.Destination)
+	if err != nil {
+		return valInvalid, err
+	}
 
-	return s.delete(path, true)
+	return s.setTimes(destPath, task.SetAtime, task.SetMtime)
 }
 
-func (s *session) deleteSingle(path string) (bool, error) {
-	start := time.Now()
-	defer func() {
-		log.Debugf("deleteSingle took %s", time.Since(start))
-	}()
-
-	return s.delete(path, false)
-}
+// dispatchTask runs the operation the fields of the task ask for.
+func (s *session) dispatchTask(task *task) (string, error) {
+	if task.Hello {
+		return s.hello(task.Binary)
+	}
 
-func concurrentRemove(path string, recursive bool) error {
-	fi, err := os.Stat(path)
-	if err != nil {
-		return err
+	if task.Stats {
+		return s.stats()
 	}
 
-	if !fi.IsDir() || !recursive {
-		return os.Remove(path)
+	if task.Admin != nil {
+		return s.admin(*task.Admin, task.Value)
 	}
 
-	f, err := os.Open(path)
-	if err != nil {
-		return err
+	if task.DumpTree {
+		return s.dumpTree()
 	}
-	defer f.Close()
 
-	names, err := f.Readdirnames(-1)
-	if err != nil {
-		return err
+	if task.ListSpeculations {
+		return s.listSpeculations()
 	}
 
-	eg := &errgroup.Group{}
-	for _, n := range names {
-		path := path + "/" + n
-		eg.Go(func() error {
-			return concurrentRemove(path, true)
-		})
+	if task.ExistenceMany != nil {
+		return s.existenceMany(task.ExistenceMany)
 	}
 
-	if err := eg.Wait(); err != nil {
-		return err
+	if task.FirstExisting != nil {
+		return s.firstExisting(task.FirstExisting)
 	}
 
-	return os.Remove(path)
-}
+	if task.MoveMany != nil {
+		return s.moveMany(task.MoveMany)
+	}
 
-func (s *session) delete(path string, recursive bool) (bool, error) {
-	if f := s.findSpeculativeFile(path); f != nil {
-		if f.isNew {
-			return false, nil
+	if task.MkdirMany != nil {
+		var perm *os.FileMode
+		if task.Permission != nil {
+			p := taskMode(*task.Permission, task.FullMode)
+			perm = &p
 		}
 
-		f.isNew = true
+		return s.mkdirMany(task.MkdirMany, perm, task.FullMode)
+	}
+
+	if task.Open != nil {
+		openPath, err := s.normalizePath(*task.Open)
+		if err != nil {
+			return valInvalid, err
+		}
+
+		var perm *os.FileMode
+		if task.Permission != nil {
+			p := taskMode(*task.Permission, task.FullMode)
+			perm = &p
+		}
+
+		return s.openHandle(openPath, perm)
+	}
+
+	if task.Handle != nil && task.Append != nil {
+		return s.appendHandle(*task.Handle, task.Append)
+	}
+
+	if task.CloseHandle != nil {
+		return s.closeHandle(*task.CloseHandle)
+	}
+
+	// Rejected paths are an answer here rather than an error.
+	if task.InRoot {
+		return s.inRoot(task.Destination)
+	}
+
+	destPath, err := s.normalizePath(task.Destination)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	var perm *os.FileMode
+	if task.Permission != nil {
+		p := taskMode(*task.Permission, task.FullMode)
+		perm = &p
+	}
+
+	var dirPerm *os.FileMode
+	if task.DirPermission != nil {
+		p := taskMode(*task.DirPermission, task.FullMode)
+		dirPerm = &p
+	}
+
+	opts := &writeOptions{
+		perm:      perm,
+		overwrite: task.Overwrite == nil || *task.Overwrite,
+		uid:       task.UID,
+		gid:       task.GID,
+		atomic:    task.Atomic,
+		sparse:    task.Sparse,
+		storeSize: task.StoreSizeXattr,
+		fsync:     task.Fsync,
+
+		copyXattrs:      task.CopyXattrs,
+		xattrBestEffort: task.BestEffort,
+
+		makeParents: s.cfg.makeParents,
+		dirPerm:     dirPerm,
+	}
+
+	if task.MakeParents != nil {
+		opts.makeParents = *task.MakeParents
+	}
+
+	// Compare-and-create: fail with "exists" instead of touching the file.
+	if task.CreateExclusive {
+		opts.overwrite = false
+	}
+
+	if task.Relink {
+		if task.SourcePath == nil {
+			return s.needMoreParameters()
+		}
+
+		// The link target is stored verbatim and may be relative to "dest",
+		// but what it resolves to must be allowed like any other path.
+		target := *task.SourcePath
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(destPath), target)
+		}
+		if err := s.checkAllowed(filepath.Clean(target)); err != nil {
+			return valInvalid, err
+		}
+
+		return s.relink(*task.SourcePath, destPath, task.Force)
+	}
+
+	if task.CloneMeta {
+		if task.SourcePath == nil {
+			return s.needMoreParameters()
+		}
+
+		srcPath, err := s.normalizePath(*task.SourcePath)
+		if err != nil {
+			return valInvalid, err
+		}
+
+		return s.cloneMeta(srcPath, destPath)
+	}
+
+	if task.SourcePath != nil {
+		srcPath, err := s.normalizePath(*task.SourcePath)
+		if err != nil {
+			return valInvalid, err
+		}
+
+		if task.Sync {
+			return s.syncDir(srcPath, destPath, task.DeleteExtraneous)
+		}
+
+		if task.CopyRecursive {
+			filter, err := newCopyFilter(task.Include, task.Exclude, task.MaxFileBytes)
+			if err != nil {
+				return valFalse, err
+			}
+
+			return s.copyRecursive(srcPath, destPath, filter, opts)
+		}
+
+		if task.Into {
+			destPath, err = s.intoDir(srcPath, destPath)
+			if err != nil {
+				return valFalse, err
+			}
+		}
+
+		if task.Move {
+			// Replacing a directory is destructive, so it's never the default.
+			replaceDir := task.Overwrite != nil && *task.Overwrite
+
+			if task.Backup {
+				return s.withBackup(destPath, func() (string, error) {
+					return s.move(srcPath, destPath, replaceDir)
+				})
+			}
+
+			return s.move(srcPath, destPath, replaceDir)
+		}
+
+		if task.Backup {
+			return s.withBackup(destPath, func() (string, error) {
+				return s.copyByRename(srcPath, destPath, opts)
+			})
+		}
+
+		if task.Clone {
+			return s.cloneFile(srcPath, destPath, opts)
+		}
+
+		if task.CopyReplace {
+			return s.copyReplace(srcPath, destPath, task.Replacements, opts)
+		}
+
+		if task.NormalizeEOL != nil {
+			return s.copyNormalizeEOL(srcPath, destPath, *task.NormalizeEOL, opts)
+		}
+
+		if task.ExpectSrcSHA256 != nil {
+			return s.copyVerified(srcPath, destPath, *task.ExpectSrcSHA256, opts)
+		}
+
+		if task.ReportChanged {
+			changed, err := s.filesDiffer(srcPath, destPath)
+			if err != nil {
+				return valFalse, err
+			}
+
+			res, err := s.copyFile(srcPath, destPath, opts)
+			return reportChanged(res, err, changed)
+		}
+
+		if task.VerifyAfterWrite {
+			res, err := s.copyFile(srcPath, destPath, opts)
+			return s.verifyWritten(res, err, destPath, func() (io.ReadCloser, error) {
+				return os.Open(srcPath)
+			})
+		}
+
+		return s.copyFile(srcPath, destPath, opts)
+	}
+
+	if task.Untar != nil {
+		return s.untar(task.Untar, destPath)
+	}
+
+	if task.Populate != nil {
+		return s.populate(destPath, task.Populate, dirPerm, opts, task.VerifyAfterWrite)
+	}
+
+	if task.AppendRotate {
+		if task.Content == nil || task.MaxBytes == nil {
+			return s.needMoreParameters()
+		}
+
+		return s.appendRotate(task.Content, destPath, *task.MaxBytes, perm)
+	}
+
+	if task.Cas {
+		if task.Expected == nil || task.Content == nil {
+			return s.needMoreParameters()
+		}
+
+		return s.compareAndSwap(destPath, task.Expected, task.Content, opts)
+	}
+
+	if task.ContentJSON != nil {
+		data, err := marshalJSONContent(task.ContentJSON)
+		if err != nil {
+			return valFalse, err
+		}
+
+		// A half-written config is as bad as a malformed one.
+		opts.atomic = true
+		return s.createFile(data, destPath, opts)
+	}
+
+	if task.Content != nil {
+		if task.TrailingNewline {
+			task.Content = ensureTrailingNewline(task.Content)
+		}
+
+		if task.SkipUnchanged {
+			unchanged, err := s.unchanged(task.Content, destPath, perm)
+			if err != nil {
+				return valFalse, err
+			}
+
+			if unchanged {
+				return valUnchanged, nil
+			}
+		}
+
+		if task.ReportChanged {
+			unchanged, err := s.unchanged(task.Content, destPath, nil)
+			if err != nil {
+				return valFalse, err
+			}
+
+			res, err := s.createFile(task.Content, destPath, opts)
+			return reportChanged(res, err, !unchanged)
+		}
+
+		if task.VerifyAfterWrite {
+			res, err := s.createFile(task.Content, destPath, opts)
+			return s.verifyWritten(res, err, destPath, func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(task.Content)), nil
+			})
+		}
+
+		return s.createFile(task.Content, destPath, opts)
+	}
+
+	if task.Fallocate != nil {
+		return s.fallocate(destPath, *task.Fallocate, opts)
+	}
+
+	if task.Zero {
+		return s.zero(destPath)
+	}
+
+	if task.Mktemp {
+		return s.mktemp(destPath, task.Prefix)
+	}
+
+	if task.Touch {
+		return s.touch(destPath, opts)
+	}
+
+	if task.Speculate || task.SpeculateAlias {
+		if err := s.speculateFile(destPath, perm, dirPerm, task.WaitOpen); err != nil {
+			return valFalse, err
+		}
+
+		return valTrue, nil
+	}
+
+	if task.Existence {
+		if s.cachedExistence(destPath) {
+			return valTrue, nil
+		}
+		return valFalse, nil
+	}
+
+	if task.Mkdir {
+		if err := s.mkdir(destPath, perm); err != nil {
+			return valFalse, err
+		}
+		return valTrue, err
+	}
+
+	if task.ListDirStream {
+		chunkSize := defaultListChunkSize
+		if task.ChunkSize != nil {
+			chunkSize = *task.ChunkSize
+		}
+
+		return s.listDirStream(destPath, chunkSize)
+	}
+
+	if task.ListDir || task.ListDirDirs || task.ListDirFiles {
+		filter := filterAll
+		switch {
+		case task.ListDir || task.ListDirDirs && task.ListDirFiles:
+			// List everything.
+		case task.ListDirDirs:
+			filter = filterDirs
+		case task.ListDirFiles:
+			filter = filterFiles
+		}
+
+		var since time.Time
+		if task.ModifiedSince != nil {
+			since = time.Unix(0, *task.ModifiedSince)
+		}
+
+		files, err := s.listDir(destPath, filter, since)
+		if err != nil {
+			return "[]", err
+		}
+
+		if task.Sorted {
+			sort.Strings(files)
+		}
+
+		j, err := json.Marshal(files)
+		if err != nil {
+			return "[]", err
+		}
+
+		return string(j), nil
+	}
+
+	if task.Walk {
+		maxDepth := -1
+		if task.MaxDepth != nil {
+			if *task.MaxDepth < 1 {
+				return "[]", fmt.Errorf("invalid max_depth: %d", *task.MaxDepth)
+			}
+			maxDepth = *task.MaxDepth
+		}
+
+		pattern := ""
+		if task.Pattern != nil {
+			pattern = *task.Pattern
+		}
+
+		var since time.Time
+		if task.ModifiedSince != nil {
+			since = time.Unix(0, *task.ModifiedSince)
+		}
+
+		return s.walkList(destPath, maxDepth, pattern, since)
+	}
+
+	if task.EmptyDir {
+		return s.emptyDir(destPath, task.Recursive)
+	}
+
+	if task.CancelSpeculation {
+		return s.cancelSpeculation(destPath)
+	}
+
+	if task.Delete {
+		succeeded, err := s.deleteSingle(destPath)
+		var res string
+		if succeeded {
+			res = valTrue
+		} else {
+			res = valFalse
+		}
+
+		// Pruning is best effort; dest has been deleted either way.
+		if succeeded && task.PruneEmptyParents {
+			var stopAt *string
+			if task.StopAt != nil {
+				p, err := s.normalizePath(*task.StopAt)
+				if err != nil {
+					return res, err
+				}
+				stopAt = &p
+			}
+
+			if _, err := s.pruneEmptyParents(destPath, stopAt); err != nil {
+				log.Error(err)
+			}
+		}
+
+		return res, err
+	}
+
+	if task.EnsurePerm {
+		if perm == nil {
+			return s.needMoreParameters()
+		}
+
+		// With "full_mode", the mode is exactly "perm", special bits included.
+		return s.ensurePerm(destPath, *perm, task.FullMode)
+	}
+
+	if task.ChmodRecursive {
+		filePerm := perm
+		if dirPerm == nil {
+			dirPerm = perm
+		}
+		if task.FilePermission != nil {
+			p := taskMode(*task.FilePermission, task.FullMode)
+			filePerm = &p
+		}
+
+		return s.chmodRecursive(destPath, dirPerm, filePerm)
+	}
+
+	if task.ChownRecursive {
+		return s.chownRecursive(destPath, task.UID, task.GID, task.BestEffort)
+	}
+
+	if task.Statfs {
+		return s.statfs(destPath)
+	}
+
+	if task.IsMountpoint {
+		return s.isMountpoint(destPath)
+	}
+
+	if task.Writable {
+		return s.writable(destPath)
+	}
+
+	if task.FsyncDir {
+		return s.fsyncDir(destPath)
+	}
+
+	if task.TreeHash {
+		return s.treeHash(destPath)
+	}
+
+	if task.WaitSize != nil {
+		if task.TimeoutMS == nil {
+			return s.needMoreParameters()
+		}
+
+		return s.waitSize(destPath, *task.WaitSize, time.Duration(*task.TimeoutMS)*time.Millisecond)
+	}
+
+	if task.Inode {
+		return s.inode(destPath)
+	}
+
+	if task.Nlink {
+		return s.nlink(destPath)
+	}
+
+	if task.Realpath {
+		return s.realpath(destPath)
+	}
+
+	if task.CleanTemp {
+		if task.Pattern == nil || task.OlderThanMS == nil {
+			return s.needMoreParameters()
+		}
+
+		return s.cleanTemp(destPath, *task.Pattern, time.Duration(*task.OlderThanMS)*time.Millisecond)
+	}
+
+	if task.VerifySHA256 != nil {
+		return s.verifySHA256(destPath, *task.VerifySHA256)
+	}
+
+	if task.ReadHead != nil {
+		return s.readHead(destPath, *task.ReadHead)
+	}
+
+	if task.ReadRange {
+		if task.Offset == nil || task.Length == nil {
+			return s.needMoreParameters()
+		}
+
+		return s.readRange(destPath, *task.Offset, *task.Length)
+	}
+
+	if task.DeleteRecursive {
+		sameFS := task.SameFSOnly == nil || *task.SameFSOnly
+		succeeded, err := s.deleteRecursive(destPath, sameFS)
+		var res string
+		if succeeded {
+			res = valTrue
+		} else {
+			res = valFalse
+		}
+
+		return res, err
+	}
+
+	return s.needMoreParameters()
+}
+
+// Stable error codes returned in verbose mode so that clients can branch on
+// failures without parsing messages.
+const (
+	codeNotExist    = "ENOENT"
+	codeExists      = "EEXIST"
+	codePermission  = "EPERM"
+	codeNoSpace     = "ENOSPC"
+	codeInvalid     = "EINVAL"
+	codeTimeout     = "ETIMEOUT"
+	codeNotDir      = "ENOTDIR"
+	codeIsDir       = "EISDIR"
+	codeNotEmpty    = "ENOTEMPTY"
+	codeIO          = "EIO"
+	codeUnsupported = "ENOTSUP"
+	codeUnknown     = "EUNKNOWN"
+)
+
+// errorCode classifies err into one of the stable error codes.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errExists):
+		return codeExists
+	case errors.Is(err, errTooLarge):
+		return codeNoSpace
+	case errors.Is(err, errUnsupported):
+		return codeUnsupported
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return codeTimeout
+	}
+
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return codeUnknown
+	}
+
+	switch errno {
+	case syscall.ENOENT:
+		return codeNotExist
+	case syscall.EEXIST:
+		return codeExists
+	case syscall.EPERM, syscall.EACCES, syscall.EROFS:
+		return codePermission
+	case syscall.ENOSPC, syscall.EDQUOT, syscall.EFBIG:
+		return codeNoSpace
+	case syscall.EINVAL, syscall.ENAMETOOLONG, syscall.ELOOP, syscall.EXDEV:
+		return codeInvalid
+	case syscall.ETIMEDOUT:
+		return codeTimeout
+	case syscall.ENOTDIR:
+		return codeNotDir
+	case syscall.EISDIR:
+		return codeIsDir
+	case syscall.ENOTEMPTY:
+		return codeNotEmpty
+	case syscall.EIO:
+		return codeIO
+	case syscall.ENOTSUP:
+		return codeUnsupported
+	}
+
+	return codeUnknown
+}
+
+type errorResult struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// errorResponse replaces a bare failure response with the error and its code.
+// A task that couldn't be parsed or was incomplete is always invalid.
+func (s *session) errorResponse(res string, err error) (string, error) {
+	code := errorCode(err)
+	if res == valInvalid && code == codeUnknown {
+		code = codeInvalid
+	}
+
+	j, jerr := json.Marshal(&errorResult{
+		Error: err.Error(),
+		Code:  code,
+	})
+	if jerr != nil {
+		return res, err
+	}
+
+	return string(j), err
+}
+
+type needMoreParametersHint struct {
+	Error      string   `json:"error"`
+	Code       string   `json:"code"`
+	Recognized []string `json:"recognized"`
+}
+
+// recognizedFields lists the JSON field names accepted in a task.
+func recognizedFields() []string {
+	t := reflect.TypeOf(task{})
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+
+	return fields
+}
+
+var errNeedMoreParameters = errors.New("need more parameters")
+
+func (s *session) needMoreParameters() (string, error) {
+	err := errNeedMoreParameters
+	if !s.cfg.verbose {
+		return valInvalid, err
+	}
+
+	j, jerr := json.Marshal(&needMoreParametersHint{
+		Error:      err.Error(),
+		Code:       codeInvalid,
+		Recognized: recognizedFields(),
+	})
+	if jerr != nil {
+		return valInvalid, jerr
+	}
+
+	return string(j), err
+}
+
+// parseTask decodes the input, rejecting unknown fields unless lenient parsing
+// is configured for older clients.
+func (s *session) parseTask(input []byte) (*task, error) {
+	var t task
+	if s.cfg.lenientJSON {
+		if err := json.Unmarshal(input, &t); err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("invalid task: %w", err)
+	}
+
+	if dec.More() {
+		return nil, fmt.Errorf("invalid task: unexpected data after task")
+	}
+
+	return &t, nil
+}
+
+// intoDir returns the path to place src inside the dest directory.
+func (s *session) intoDir(srcPath, destDir string) (string, error) {
+	if t := s.findSpeculativeDir(destDir); t != nil && t.speculative {
+		return "", fmt.Errorf("not a directory: %s", destDir)
+	}
+
+	st, err := os.Stat(destDir)
+	if err != nil {
+		return "", err
+	}
+
+	if !st.IsDir() {
+		return "", fmt.Errorf("not a directory: %s", destDir)
+	}
+
+	return filepath.Join(destDir, filepath.Base(srcPath)), nil
+}
+
+// normalizePath makes the path absolute.
+// Relative paths are resolved against the configured root, or against
+// the working directory of the daemon with a warning if no root is configured.
+func (s *session) normalizePath(path string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("normalizePath took %s", time.Since(start))
+	}()
+
+	if !filepath.IsAbs(path) && s.cfg.root == "" {
+		log.Warnf("relative path resolved against working directory: %s", path)
+	}
+
+	abs, err := s.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.checkAllowed(abs); err != nil {
+		return "", err
+	}
+
+	return abs, nil
+}
+
+// resolvePath is normalizePath without the --allow-prefix check and logging,
+// for a path the task normalizes again.
+func (s *session) resolvePath(path string) (string, error) {
+	// There's an assumption that no symbolic link exists.
+	switch {
+	case filepath.IsAbs(path):
+		return filepath.Clean(path), nil
+	case s.cfg.root != "":
+		return filepath.Join(s.cfg.root, path), nil
+	default:
+		return filepath.Abs(path)
+	}
+}
+
+// inRoot reports whether the path resolves under --root and passes the
+// --allow-prefix check, just as tasks would see it. Without --root, every
+// allowed path is in the root.
+func (s *session) inRoot(path string) (string, error) {
+	abs, err := s.normalizePath(path)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return valFalse, nil
+		}
+		return valInvalid, err
+	}
+
+	if s.cfg.root != "" && !isUnder(abs, s.cfg.root) {
+		return valFalse, nil
+	}
+
+	return valTrue, nil
+}
+
+// checkAllowed rejects the path unless it's under one of the allowed prefixes.
+func (s *session) checkAllowed(absPath string) error {
+	if s.allowed(absPath) {
+		return nil
+	}
+
+	metrics.blockedRequests.Add(1)
+	log.Warnf("path not allowed: %s", absPath)
+	return fmt.Errorf("%w: path not allowed: %s", os.ErrPermission, absPath)
+}
+
+// allowed is checkAllowed without counting and logging a blocked path.
+func (s *session) allowed(absPath string) bool {
+	if len(s.cfg.allowPrefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range s.cfg.allowPrefixes {
+		if isUnder(absPath, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isUnder reports whether the clean absolute path is dir or inside it.
+func isUnder(absPath, dir string) bool {
+	if dir == "/" || absPath == dir {
+		return true
+	}
+
+	return strings.HasPrefix(absPath, dir+"/")
+}
+
+// deleteRecursive deletes the path and its descendants.
+// If sameFS is set, it refuses to cross filesystem boundaries.
+func (s *session) deleteRecursive(path string, sameFS bool) (bool, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("deleteRecursive took %s", time.Since(start))
+	}()
+
+	return s.delete(path, true, sameFS)
+}
+
+func (s *session) deleteSingle(path string) (bool, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("deleteSingle took %s", time.Since(start))
+	}()
+
+	return s.delete(path, false, false)
+}
+
+// fileDevice returns the device of the file. Tests replace it to simulate mounts.
+var fileDevice = statDevice
+
+// checkDevice returns an error if the file isn't on the device dev.
+func checkDevice(path string, fi os.FileInfo, dev *uint64) error {
+	d, err := fileDevice(fi)
+	if err != nil {
+		return err
+	}
+
+	if d != *dev {
+		return fmt.Errorf("refusing to cross filesystem boundary: %s", path)
+	}
+
+	return nil
+}
+
+// concurrentRemove removes the path.
+// If dev is not nil, entries on other devices are never removed.
+func concurrentRemove(path string, recursive bool, dev *uint64) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if dev != nil {
+		if err := checkDevice(path, fi, dev); err != nil {
+			return err
+		}
+	}
+
+	if !fi.IsDir() || !recursive {
+		return os.Remove(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+
+	eg := &errgroup.Group{}
+	for _, n := range names {
+		path := path + "/" + n
+		eg.Go(func() error {
+			return concurrentRemove(path, true, dev)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (s *session) delete(path string, recursive, sameFS bool) (bool, error) {
+	var dev *uint64
+	if recursive && sameFS {
+		if fi, err := os.Stat(path); err == nil {
+			d, err := fileDevice(fi)
+			if err != nil && !errors.Is(err, errUnsupported) {
+				return false, err
+			}
+			if err == nil {
+				dev = &d
+			}
+		}
+	}
+
+	if sf := s.findSpeculation(path); sf != nil && sf.withdraw() {
+		f := sf.getFutureFile()
+		if f.isNew {
+			return false, nil
+		}
+
+		f.isNew = true
 		return true, nil
 	}
 
-	if d := s.findSpeculativeDir(path); d != nil {
-		return d.delete(recursive)
+	if d := s.findSpeculativeDir(path); d != nil {
+		return d.delete(recursive, dev)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	if err := concurrentRemove(path, recursive, dev); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+type entryFilter int
+
+const (
+	filterAll entryFilter = iota
+	filterDirs
+	filterFiles
+)
+
+// listDir lists the names of the entries in the directory. Unless since is
+// zero, only entries modified after since are listed.
+func (s *session) listDir(dirPath string, filter entryFilter, since time.Time) ([]string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("listDir took %s", time.Since(start))
+	}()
+
+	if filter != filterAll || !since.IsZero() {
+		return s.listDirFiltered(dirPath, filter, since)
+	}
+
+	if d := s.findSpeculativeDir(dirPath); d != nil {
+		return d.logicalList()
+	}
+
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
+
+// listDirFiltered lists the entries of the kind filter selects, modified
+// after since unless it's zero.
+func (s *session) listDirFiltered(dirPath string, filter entryFilter, since time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	d := s.findSpeculativeDir(dirPath)
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if filter != filterAll && e.IsDir() != (filter == filterDirs) {
+			continue
+		}
+
+		if d != nil && !d.logicallyExists(e.Name()) {
+			continue
+		}
+
+		if !since.IsZero() {
+			newer, err := modifiedAfter(e, since)
+			if err != nil {
+				return nil, err
+			}
+			if !newer {
+				continue
+			}
+		}
+
+		names = append(names, e.Name())
+	}
+
+	return names, nil
+}
+
+// modifiedAfter reports whether the entry was modified after t. An entry
+// removed since it was read is regarded as not modified.
+func modifiedAfter(e fs.DirEntry, t time.Time) (bool, error) {
+	fi, err := e.Info()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return fi.ModTime().After(t), nil
+}
+
+// readHead returns the first n bytes of the file as a base64-encoded JSON string.
+func (s *session) readHead(path string, n int) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("readHead took %s", time.Since(start))
+	}()
+
+	return s.readRange(path, 0, n)
+}
+
+// readRange returns n bytes of the file from offset as a base64-encoded JSON
+// string. Fewer bytes are returned at the end of the file.
+func (s *session) readRange(path string, offset int64, n int) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("readRange took %s", time.Since(start))
+	}()
+
+	if n < 0 {
+		return valFalse, fmt.Errorf("invalid byte count: %d", n)
+	}
+
+	if offset < 0 {
+		return valFalse, fmt.Errorf("invalid offset: %d", offset)
+	}
+
+	if maxReadHeadBytes < n {
+		n = maxReadHeadBytes
+	}
+
+	if f := s.findSpeculativeFile(path); f != nil && f.isNew {
+		return valFalse, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return valFalse, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, n)
+	read, err := file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return valFalse, err
+	}
+
+	j, err := json.Marshal(base64.StdEncoding.EncodeToString(buf[:read]))
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
+
+// mkdir returns true only if the directory is newly created.
+func (s *session) mkdir(destPath string, perm *os.FileMode) error {
+	start := time.Now()
+	defer func() {
+		log.Debugf("mkdir took %s", time.Since(start))
+	}()
+
+	// A speculatively created file doesn't exist logically, so it gives way.
+	if f := s.findSpeculativeFile(destPath); f != nil && f.isNew {
+		if _, err := s.cancelSpeculation(destPath); err != nil {
+			return err
+		}
+	}
+
+	return s.mkSpeculativeDir(destPath, perm)
+}
+
+func (s *session) existence(destPath string) bool {
+	start := time.Now()
+	defer func() {
+		log.Debugf("existence took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found {
+		return exists
+	}
+
+	_, err := os.Stat(destPath)
+	return !os.IsNotExist(err)
+}
+
+// cachedExistence is existence answered from the directory cache if
+// possible. Only tasks changing nothing use it, since a task creating or
+// removing a path would make the cache stale halfway.
+func (s *session) cachedExistence(destPath string) bool {
+	if exists, found := s.speculativeExistence(destPath); found {
+		return exists
+	}
+
+	if exists, ok := s.dirCache.get(destPath); ok {
+		return exists
+	}
+
+	fi, err := os.Stat(destPath)
+	s.dirCache.record(destPath, fi, err)
+	return !os.IsNotExist(err)
+}
+
+// speculativeExistence answers existence from the speculative tree.
+// found is false if the tree doesn't know the path.
+func (s *session) speculativeExistence(destPath string) (exists bool, found bool) {
+	if f := s.findSpeculativeFile(destPath); f != nil {
+		return !f.isNew, true
+	}
+
+	if t := s.findSpeculativeDir(destPath); t != nil {
+		return !t.speculative, true
+	}
+
+	return false, false
+}
+
+// existenceMany returns a JSON array of existence in the same order as paths.
+func (s *session) existenceMany(paths []string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("existenceMany took %s", time.Since(start))
+	}()
+
+	results, err := s.existenceAll(paths)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	j, err := json.Marshal(results)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	return string(j), nil
+}
+
+// firstExisting returns the first of the paths that exists, directories
+// included as with existence, as given in JSON, or null if none does.
+func (s *session) firstExisting(paths []string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("firstExisting took %s", time.Since(start))
+	}()
+
+	results, err := s.existenceAll(paths)
+	if err != nil {
+		return valInvalid, err
+	}
+
+	for i, exists := range results {
+		if !exists {
+			continue
+		}
+
+		j, err := json.Marshal(paths[i])
+		if err != nil {
+			return valInvalid, err
+		}
+
+		return string(j), nil
+	}
+
+	return valInvalid, nil
+}
+
+// existenceAll checks the existence of the paths concurrently, using the
+// directory cache like cachedExistence.
+func (s *session) existenceAll(paths []string) ([]bool, error) {
+	results := make([]bool, len(paths))
+	stats := make([]func(), len(paths))
+	eg := &errgroup.Group{}
+
+	// The speculative tree isn't goroutine-safe; only stat concurrently.
+	for i, p := range paths {
+		i := i
+		path, err := s.normalizePath(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists, found := s.speculativeExistence(path); found {
+			results[i] = exists
+			continue
+		}
+
+		if exists, ok := s.dirCache.get(path); ok {
+			results[i] = exists
+			continue
+		}
+
+		eg.Go(func() error {
+			fi, err := os.Stat(path)
+			results[i] = !os.IsNotExist(err)
+			stats[i] = func() { s.dirCache.record(path, fi, err) }
+			return nil
+		})
+	}
+
+	eg.Wait()
+
+	// Nor is the cache; fill it after the stats.
+	for _, record := range stats {
+		if record != nil {
+			record()
+		}
+	}
+
+	return results, nil
+}
+
+// speculateFile opens the file speculatively. Missing parent directories are
+// created with dirPerm, or the default mode if nil. Unless wait is set, only an
+// open that has already failed is reported.
+func (s *session) speculateFile(destPath string, perm, dirPerm *os.FileMode, wait bool) error {
+	start := time.Now()
+	defer func() {
+		log.Debugf("speculateFile took %s", time.Since(start))
+	}()
+
+	// The speculative tree stays empty, so writes always open files on demand.
+	if s.cfg.noSpeculation {
+		return nil
+	}
+
+	file, err := s.addSpeculativeFile(destPath, perm, dirPerm)
+	if err != nil {
+		return err
+	}
+
+	if wait {
+		future, err := file.waitFutureFile(s.ctx)
+		if err != nil {
+			return err
+		}
+		if future.err != nil {
+			return future.err
+		}
 	}
 
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+	// Report an open that has already failed without waiting for one in flight.
+	select {
+	case <-file.done:
+		if err := file.file.err; err != nil {
+			return err
 		}
+	default:
+	}
 
-		return false, err
+	if file.lru == nil {
+		file.lru = s.speculations.PushBack(file)
 	}
 
-	if err := concurrentRemove(path, recursive); err != nil {
-		return false, err
+	if s.cfg.sharedSpeculation {
+		sharedSpeculations.register(destPath, file)
 	}
 
-	return true, nil
+	// Failing to dispose another file doesn't affect this speculation.
+	if 0 < s.cfg.maxSpeculations && s.cfg.maxSpeculations < s.speculations.Len() {
+		if err := s.evictSpeculation(); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return nil
 }
 
-func (s *session) listDir(dirPath string) ([]string, error) {
-	start := time.Now()
-	defer func() {
-		log.Debugf("listDir took %s", time.Since(start))
-	}()
+// evictSpeculation disposes the oldest unclaimed speculative file.
+func (s *session) evictSpeculation() error {
+	file := s.speculations.Remove(s.speculations.Front()).(*speculativeFile)
+	file.lru = nil
 
-	if d := s.findSpeculativeDir(dirPath); d != nil {
-		return d.logicalList()
+	// Already dropped if another session has claimed it.
+	if file.parent.childFiles[file.name] == file {
+		delete(file.parent.childFiles, file.name)
 	}
 
-	f, err := os.Open(dirPath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
+	log.Infof("speculation evicted: %s/%s", file.parent.getPath(), file.name)
 
-	return f.Readdirnames(-1)
+	return file.disposeUnused()
 }
 
-// mkdir returns true only if the directory is newly created.
-func (s *session) mkdir(destPath string, perm *os.FileMode) error {
-	start := time.Now()
-	defer func() {
-		log.Debugf("mkdir took %s", time.Since(start))
-	}()
-
-	return s.mkSpeculativeDir(destPath, perm)
-}
+// cancelSpeculation disposes the speculative file before finalize to free its
+// descriptor, and reports whether one was found.
+func (s *session) cancelSpeculation(destPath string) (string, error) {
+	dir := s.findSpeculativeDir(filepath.Dir(destPath))
+	if dir == nil {
+		return valFalse, nil
+	}
 
-func (s *session) existence(destPath string) bool {
-	start := time.Now()
-	defer func() {
-		log.Debugf("existence took %s", time.Since(start))
-	}()
+	name := filepath.Base(destPath)
+	file, ok := dir.file(name)
+	if !ok {
+		return valFalse, nil
+	}
+	delete(dir.childFiles, name)
 
-	if f := s.findSpeculativeFile(destPath); f != nil {
-		return !f.isNew
+	if file.lru != nil {
+		s.speculations.Remove(file.lru)
+		file.lru = nil
 	}
 
-	if t := s.findSpeculativeDir(destPath); t != nil {
-		return !t.speculative
+	if err := file.disposeUnused(); err != nil {
+		return valFalse, err
 	}
 
-	_, err := os.Stat(destPath)
-	return !os.IsNotExist(err)
+	return valTrue, nil
+}
+
+// writeOptions controls how the destination is written.
+type writeOptions struct {
+	perm      *os.FileMode
+	overwrite bool // Fail with errExists instead of overwriting if false.
+	uid       *int
+	gid       *int
+	atomic    bool // Write to a temporary file and rename it to the destination.
+	sparse    bool // Copy only the data regions of the source, leaving holes.
+	storeSize bool // Record the written size in the size xattr.
+	fsync     bool // Sync the file before reporting success.
+
+	// copyXattrs replicates the extended attributes of the source on copy.
+	// Failures of individual attributes are only logged if xattrBestEffort.
+	copyXattrs      bool
+	xattrBestEffort bool
+
+	// makeParents creates missing parent directories with dirPerm. Otherwise
+	// only a speculated destination gets its parents, by speculation.
+	makeParents bool
+	dirPerm     *os.FileMode
 }
 
-func (s *session) speculateFile(destPath string, perm *os.FileMode) error {
+var errExists = errors.New("file already exists")
+
+// createDest opens the destination file for writing.
+// The returned bool reports whether the file was newly created by this session.
+func (s *session) createDest(destPath string, opts *writeOptions) (*os.File, bool, error) {
 	start := time.Now()
 	defer func() {
-		log.Debugf("speculateFile took %s", time.Since(start))
+		log.Debugf("createDest took %s", time.Since(start))
 	}()
 
-	if _, err := s.addSpeculativeFile(destPath, perm); err != nil {
-		return err
+	perm := opts.perm
+
+	// Leave the speculative fd of an existing file for finalize.
+	if !opts.overwrite {
+		if f := s.findSpeculativeFile(destPath); f != nil && !f.isNew {
+			return nil, false, errExists
+		}
 	}
 
-	return nil
-}
+	if file := s.useOpenFile(destPath); file != nil {
+		log.Debugf("open file found at: %s", destPath)
 
-func (s *session) createDest(destPath string, perm *os.FileMode) (*os.File, error) {
-	start := time.Now()
-	defer func() {
-		log.Debugf("createDest took %s", time.Since(start))
-	}()
+		if perm != nil {
+			if err := file.Chmod(*perm); err != nil {
+				return nil, false, err
+			}
+		}
+
+		return file, true, nil
+	}
 
 	if f := s.useSpeculativeFile(destPath); f != nil {
 		log.Debugf("speculative file found at: %s", destPath)
 
 		if f.err != nil {
-			return nil, f.err
+			return nil, false, f.err
 		}
 
 		if perm == nil {
-			return f.file, nil
+			return f.file, f.isNew, nil
 		}
 
-		if f.perm == *perm {
-			return f.file, nil
+		if permEqual(f.perm, *perm) {
+			return f.file, f.isNew, nil
 		}
 
 		if err := f.file.Chmod(*perm); err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
-		return f.file, nil
+		return f.file, f.isNew, nil
 	}
 
 	log.Debug("speculative file not found")
 
+	if opts.makeParents {
+		if err := s.makeParents(destPath, opts.dirPerm); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return createDestOnDisk(destPath, opts)
+}
+
+// createDestOnDisk is createDest for a file neither the speculative tree nor
+// the session knows about, and is safe to call concurrently.
+func createDestOnDisk(destPath string, opts *writeOptions) (*os.File, bool, error) {
+	perm := opts.perm
+
 	var newPerm os.FileMode
 	if perm == nil {
 		newPerm = 0666
 	} else {
 		newPerm = *perm
 	}
-	file, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE, newPerm)
+
+	created := false
+	var file *os.File
+	var err error
+	if opts.overwrite {
+		file, err = os.OpenFile(destPath, os.O_WRONLY, 0)
+	} else {
+		err = os.ErrNotExist
+	}
 	if err != nil {
-		return nil, err
+		if !os.IsNotExist(err) {
+			return nil, false, err
+		}
+
+		file, err = os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, newPerm)
+		if err != nil {
+			if os.IsExist(err) && !opts.overwrite {
+				return nil, false, errExists
+			}
+			return nil, false, err
+		}
+		created = true
 	}
 
 	if perm == nil {
-		return file, nil
+		return file, created, nil
 	}
 
 	st, err := file.Stat()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	if st.Mode().Perm() == *perm {
-		return file, nil
+	if permEqual(st.Mode(), *perm) {
+		return file, created, nil
 	}
 
 	if err := file.Chmod(*perm); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	return file, nil
+	return file, created, nil
+}
+
+// removeIfNoSpace removes the file created by the failed write
+// so that a truncated file isn't mistaken for valid content.
+func removeIfNoSpace(path string, created bool, writeErr error) {
+	if !errors.Is(writeErr, syscall.ENOSPC) {
+		return
+	}
+
+	removePartial(path, created)
+}
+
+// removePartial removes the partially written file unless it existed before.
+func removePartial(path string, created bool) {
+	if !created {
+		return
+	}
+
+	log.Warnf("removing partially written file: %s", path)
+	if err := os.Remove(path); err != nil {
+		log.Error(err)
+	}
+}
+
+var errTooLarge = errors.New("exceeds the maximum write size")
+
+// checkWriteSize fails if writing n bytes exceeds the configured limit.
+func (s *session) checkWriteSize(n int64) error {
+	if 0 < s.cfg.maxWriteBytes && s.cfg.maxWriteBytes < n {
+		return fmt.Errorf("%w: %d bytes", errTooLarge, n)
+	}
+
+	return nil
+}
+
+// writeFile writes to the destination. Tests replace it to simulate failures.
+var writeFile = func(file *os.File, b []byte) (int, error) {
+	return file.Write(b)
 }
 
 func truncateFile(file *os.File, oldBytes, writtenBytes int64) {
@@ -824,7 +2379,7 @@ func truncateFile(file *os.File, oldBytes, writtenBytes int64) {
 	file.Truncate(writtenBytes)
 }
 
-func (s *session) copyFile(srcPath, destPath string, perm *os.FileMode) (string, error) {
+func (s *session) copyFile(srcPath, destPath string, opts *writeOptions) (string, error) {
 	openSrc := func() (*os.File, error) {
 		start := time.Now()
 		defer func() {
@@ -848,8 +2403,18 @@ func (s *session) copyFile(srcPath, destPath string, perm *os.FileMode) (string,
 		}()
 	}()
 
-	dest, err := s.createDest(destPath, perm)
+	// Refuse early so that an existing destination is left intact.
+	if srcStat, err := src.Stat(); err == nil {
+		if err := s.checkWriteSize(srcStat.Size()); err != nil {
+			return valFalse, err
+		}
+	}
+
+	dest, created, err := s.createDest(destPath, opts)
 	if err != nil {
+		if errors.Is(err, errExists) {
+			return valExists, nil
+		}
 		return valFalse, err
 	}
 	defer func() {
@@ -869,7 +2434,20 @@ func (s *session) copyFile(srcPath, destPath string, perm *os.FileMode) (string,
 
 	destOldBytes := destStat.Size()
 
-	buf := make([]byte, copyBufferSize)
+	buf := make([]byte, s.cfg.copyBufferSize)
+
+	if opts.sparse {
+		size, err := copySparse(dest, src, buf)
+		if err == nil {
+			return s.finishCopy(src, dest, size, opts)
+		}
+		if !errors.Is(err, errUnsupported) {
+			removeIfNoSpace(destPath, created, err)
+			return valFalse, err
+		}
+
+		log.Debugf("holes not supported, copying all: %s", srcPath)
+	}
 
 	readFromSrc := func() (int, error) {
 		start := time.Now()
@@ -895,13 +2473,9 @@ func (s *session) copyFile(srcPath, destPath string, perm *os.FileMode) (string,
 			log.Debugf("writeToDest took %s", time.Since(start))
 		}()
 
-		wb, err := dest.Write(buf[:n])
-		if err != nil {
-			return err
-		}
-
+		wb, err := writeFile(dest, buf[:n])
 		writtenBytes += int64(wb)
-		return nil
+		return err
 	}
 
 	for {
@@ -914,17 +2488,127 @@ func (s *session) copyFile(srcPath, destPath string, perm *os.FileMode) (string,
 			break
 		}
 
+		// The source may grow while being copied.
+		if err := s.checkWriteSize(writtenBytes + int64(n)); err != nil {
+			removePartial(destPath, created)
+			return valFalse, err
+		}
+
 		if err := writeToDest(n); err != nil {
+			removeIfNoSpace(destPath, created, err)
 			return valFalse, err
 		}
 	}
 
+	// Truncate now rather than on return so that a sync covers the size.
+	truncateFile(dest, destOldBytes, writtenBytes)
+	destOldBytes = writtenBytes
+
+	return s.finishCopy(src, dest, writtenBytes, opts)
+}
+
+// finishCopy replicates the xattrs of the source if asked to, and then
+// finishes the write. A stamped size takes precedence over a copied one.
+func (s *session) finishCopy(src, dest *os.File, size int64, opts *writeOptions) (string, error) {
+	if opts.copyXattrs {
+		if err := copyXattrs(src, dest, opts.xattrBestEffort); err != nil {
+			return valFalse, err
+		}
+	}
+
+	return s.finishWrite(dest, size, opts)
+}
+
+// finishWrite stamps the size and then syncs the file if asked to, so that
+// an error deferred by the OS, such as ENOSPC, fails the write.
+func (s *session) finishWrite(file *os.File, size int64, opts *writeOptions) (string, error) {
+	res, err := s.stampSize(file, size, opts)
+	if err != nil || !opts.fsync {
+		return res, err
+	}
+
+	if err := syncFile(file); err != nil {
+		return valFalse, err
+	}
+
+	return res, nil
+}
+
+// syncFile flushes the file to stable storage. Tests replace it to simulate
+// deferred write errors.
+var syncFile = func(file *os.File) error {
+	return file.Sync()
+}
+
+// stampSize sets the size xattr if asked to, once the content is written.
+func (s *session) stampSize(file *os.File, size int64, opts *writeOptions) (string, error) {
+	if !opts.storeSize {
+		return valTrue, nil
+	}
+
+	if err := setSizeXattr(file, size); err != nil {
+		return valFalse, err
+	}
+
 	return valTrue, nil
 }
 
-func (s *session) createFile(content []byte, destPath string, perm *os.FileMode) (string, error) {
-	dest, err := s.createDest(destPath, perm)
+// unchanged reports whether the file already has the content and perm.
+// A speculative new file is never regarded as unchanged.
+func (s *session) unchanged(content []byte, destPath string, perm *os.FileMode) (bool, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("unchanged took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return false, nil
+	}
+
+	file, err := os.Open(destPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if !st.Mode().IsRegular() || st.Size() != int64(len(content)) {
+		return false, nil
+	}
+
+	if perm != nil && !permEqual(st.Mode(), *perm) {
+		return false, nil
+	}
+
+	current, err := io.ReadAll(file)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(current, content), nil
+}
+
+func (s *session) createFile(content []byte, destPath string, opts *writeOptions) (string, error) {
+	if err := s.checkWriteSize(int64(len(content))); err != nil {
+		return valFalse, err
+	}
+
+	if opts.atomic {
+		return s.createFileAtomically(content, destPath, opts)
+	}
+
+	dest, created, err := s.createDest(destPath, opts)
 	if err != nil {
+		if errors.Is(err, errExists) {
+			return valExists, nil
+		}
 		return valFalse, err
 	}
 	defer func() {
@@ -937,6 +2621,12 @@ func (s *session) createFile(content []byte, destPath string, perm *os.FileMode)
 		}()
 	}()
 
+	return s.writeDest(dest, created, content, destPath, opts)
+}
+
+// writeDest writes the content over the opened destination. A file created
+// for it is removed if the disk turns out to be full.
+func (s *session) writeDest(dest *os.File, created bool, content []byte, destPath string, opts *writeOptions) (string, error) {
 	destStat, err := dest.Stat()
 	if err != nil {
 		return valFalse, err
@@ -950,16 +2640,57 @@ func (s *session) createFile(content []byte, destPath string, perm *os.FileMode)
 			log.Debugf("writeToDest took %s", time.Since(start))
 		}()
 
-		return dest.Write(content)
+		return writeFile(dest, content)
 	}
 
 	writtenBytes, err := writeToDest()
 	if err != nil {
+		removeIfNoSpace(destPath, created, err)
 		return valFalse, err
 	}
 
 	truncateFile(dest, destOldBytes, int64(writtenBytes))
 
+	if err := chownFile(dest, opts.uid, opts.gid); err != nil {
+		return valFalse, err
+	}
+
+	return s.finishWrite(dest, int64(writtenBytes), opts)
+}
+
+// touch creates an empty file if absent, or updates its timestamps otherwise.
+func (s *session) touch(destPath string, opts *writeOptions) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("touch took %s", time.Since(start))
+	}()
+
+	dest, created, err := s.createDest(destPath, opts)
+	if err != nil {
+		if errors.Is(err, errExists) {
+			return valExists, nil
+		}
+		return valFalse, err
+	}
+	defer func() {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := dest.Close(); err != nil {
+				log.Errorf("failed to close: %s", destPath)
+			}
+		}()
+	}()
+
+	if created {
+		return valTrue, nil
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(destPath, now, now); err != nil {
+		return valFalse, err
+	}
+
 	return valTrue, nil
 }
 
@@ -979,15 +2710,26 @@ func (s *session) finalize() {
 		s.finalized = true
 	}()
 
+	// An unfinished transaction is never committed.
+	if s.tx != nil {
+		if _, err := s.rollbackTx(); err != nil {
+			log.Error(err)
+		}
+	}
+
 	if err := s.speculativeDirTree.clean(); err != nil {
 		log.Error(err)
 	}
+	s.speculations.Init()
+	s.closeOpenFiles()
+	s.closeHandles()
 
 	s.wg.Wait()
 }
 
-func (s *session) done() {
-	s.speculativeDirTree.done()
+// done waits for all speculative opens to finish or ctx to be cancelled.
+func (s *session) done(ctx context.Context) error {
+	return s.speculativeDirTree.done(ctx)
 }
 
 func (s *session) mkSpeculativeDir(absDirPath string, perm *os.FileMode) error {
@@ -1018,7 +2760,7 @@ func (s *session) findSpeculativeDir(absDirPath string) *dirTree {
 	return s.speculativeDirTree.findDirInternal(strings.Split(absDirPath[1:], "/"))
 }
 
-func (s *session) addSpeculativeFile(absPath string, perm *os.FileMode) (*speculativeFile, error) {
+func (s *session) addSpeculativeFile(absPath string, perm, dirPerm *os.FileMode) (*speculativeFile, error) {
 	if absPath[0] != '/' {
 		log.Panicf("path must be absolute: %s", absPath)
 	}
@@ -1028,10 +2770,10 @@ func (s *session) addSpeculativeFile(absPath string, perm *os.FileMode) (*specul
 		return nil, fmt.Errorf("directory already exists: %s", absPath)
 	}
 
-	return s.speculativeDirTree.addFileInternal(strings.Split(absPath[1:], "/"), perm)
+	return s.speculativeDirTree.addFileInternal(strings.Split(absPath[1:], "/"), perm, dirPerm)
 }
 
-func (s *session) findSpeculativeFile(absPath string) *futureFile {
+func (s *session) findSpeculation(absPath string) *speculativeFile {
 	name := filepath.Base(absPath)
 	if name == "/" {
 		return nil
@@ -1042,12 +2784,20 @@ func (s *session) findSpeculativeFile(absPath string) *futureFile {
 		return nil
 	}
 
-	file, ok := dir.childFiles[name]
+	file, ok := dir.file(name)
 	if !ok {
 		return nil
 	}
 
-	return file.getFutureFile()
+	return file
+}
+
+func (s *session) findSpeculativeFile(absPath string) *futureFile {
+	if file := s.findSpeculation(absPath); file != nil {
+		return file.getFutureFile()
+	}
+
+	return nil
 }
 
 func (s *session) useSpeculativeFile(absPath string) *futureFile {
@@ -1060,5 +2810,36 @@ func (s *session) useSpeculativeFile(absPath string) *futureFile {
 		return nil
 	}
 
-	return s.speculativeDirTree.useSpeculativeFile(strings.Split(absPath[1:], "/"))
+	file := s.speculativeDirTree.useSpeculativeFile(strings.Split(absPath[1:], "/"))
+	if file == nil {
+		if !s.cfg.sharedSpeculation {
+			return nil
+		}
+
+		// Claim a speculation made by another session.
+		file = sharedSpeculations.take(absPath)
+		if file == nil {
+			return nil
+		}
+
+		metrics.speculationUsed.Add(1)
+		return file.getFutureFile()
+	}
+
+	if file.lru != nil {
+		s.speculations.Remove(file.lru)
+		file.lru = nil
+	}
+
+	if file.pooled {
+		sharedSpeculations.remove(absPath, file)
+	}
+
+	// Another session has already claimed and written this file.
+	if !file.claim() {
+		return nil
+	}
+
+	metrics.speculationUsed.Add(1)
+	return file.getFutureFile()
 }
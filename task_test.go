@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -213,7 +217,7 @@ func TestMain(m *testing.M) {
 
 func run(test func(*testpack)) func(*testing.T) {
 	return func(t *testing.T) {
-		sess := newSession()
+		sess := newSession(defaultConfig())
 		defer sess.finalize()
 		fs := createTestFS()
 		as := assert.New(t)
@@ -271,6 +275,22 @@ func Test_CopyFile(t *testing.T) {
 		p.assert.Equal(testResFalse, res)
 	}))
 
+	t.Run("make parents", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "make_parents": true, "dir_perm": %d}`,
+			p.fs.path(testDir1Dir2File1),
+			p.fs.path(testFile2),
+			testDirPerm2))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1Dir2File1).read())
+		p.assert.Equal(testDirPerm2, p.fs.dir(testDir1).mode())
+		p.assert.Equal(testDirPerm2, p.fs.dir(testDir1Dir2).mode())
+	}))
+
 	t.Run("chmod", run(func(p *testpack) {
 		p.fs.file(testFile2).write(testContent1)
 
@@ -362,6 +382,33 @@ func Test_CopyFile_Speculate(t *testing.T) {
 		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
 	}))
 
+	t.Run("three-level deep file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		deepFile := testDir1Dir2 + "/" + testDir2 + "/" + testFile1
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(deepFile)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(deepFile),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		for _, d := range []string{testDir1, testDir1Dir2, testDir1Dir2 + "/" + testDir2} {
+			tree := p.sess.findSpeculativeDir(filepath.Clean(p.fs.path(d)))
+			p.assert.NotNil(tree)
+			p.assert.False(tree.speculative, d)
+		}
+
+		p.sess.finalize()
+
+		p.assert.Equal(testContent1, p.fs.file(deepFile).read())
+	}))
+
 	t.Run("two deep files, first one discarded", run(func(p *testpack) {
 		p.fs.file(testFile1).write(testContent1)
 		p.fs.file(testFile2).write(testContent1)
@@ -458,7 +505,7 @@ func Test_CreateFile(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
 	}))
 
@@ -471,7 +518,7 @@ func Test_CreateFile(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testLongContent1, p.fs.file(testFile1).read())
 	}))
 
@@ -486,7 +533,7 @@ func Test_CreateFile(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
 	}))
 
@@ -500,6 +547,66 @@ func Test_CreateFile(t *testing.T) {
 		p.assert.Error(err)
 	}))
 
+	t.Run("make parents by default", run(func(p *testpack) {
+		p.sess.cfg.makeParents = true
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("make parents disabled by task", run(func(p *testpack) {
+		p.sess.cfg.makeParents = true
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "make_parents": false}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.dir(testDir1).exists())
+	}))
+
+	t.Run("make parents in deleted directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+		p.sess.done(context.Background())
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "delete_recursive": true}`,
+			p.fs.path(testDir1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "make_parents": true}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("make parents atomically", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "make_parents": true, "atomic": true}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
 	t.Run("chmod", run(func(p *testpack) {
 		res, err := p.sess.addTask(taskf(
 			`{"dest": "%s", "content_b64": "%s", "perm": %d}`,
@@ -510,7 +617,7 @@ func Test_CreateFile(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
 	}))
 
@@ -526,7 +633,7 @@ func Test_CreateFile(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
 	}))
 }
@@ -765,7 +872,7 @@ func Test_Delete(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.False(p.fs.file(testFile1).exists())
 	}))
 
@@ -779,7 +886,7 @@ func Test_Delete(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.False(p.fs.dir(testDir1).exists())
 	}))
 
@@ -794,7 +901,7 @@ func Test_Delete(t *testing.T) {
 		p.assert.Error(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testDir1).exists())
 	}))
 }
@@ -812,7 +919,7 @@ func Test_Delete_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testFile1).exists())
 	}))
 
@@ -830,7 +937,7 @@ func Test_Delete_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testFile1).exists())
 
 		p.sess.finalize()
@@ -849,7 +956,7 @@ func Test_Delete_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testDir1).exists())
 	}))
 
@@ -868,7 +975,7 @@ func Test_Delete_Speculate(t *testing.T) {
 		p.assert.Error(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testDir1).exists())
 	}))
 }
@@ -937,7 +1044,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testDir1File1).exists())
 	}))
 
@@ -953,7 +1060,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testDir1File1).exists())
 	}))
 
@@ -972,7 +1079,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 			p.assert.NoError(err)
 			p.assert.Equal(testResTrue, res)
 
-			p.sess.done()
+			p.sess.done(context.Background())
 			p.assert.True(p.fs.file(testDir1File1).exists())
 		}
 		{
@@ -1000,7 +1107,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.False(p.fs.file(testDir1File1).exists())
 		p.assert.True(p.fs.file(testDir1File2).exists())
 	}))
@@ -1017,7 +1124,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testDir1Dir2File1).exists())
 	}))
 
@@ -1037,7 +1144,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.False(p.fs.file(testDir1Dir2File1).exists())
 		p.assert.True(p.fs.file(testDir1Dir2File2).exists())
 	}))
@@ -1057,7 +1164,7 @@ func Test_DeleteRecursive_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testDir1File1).exists())
 
 		p.sess.finalize()
@@ -1101,7 +1208,7 @@ func Test_Existence(t *testing.T) {
 func Test_Existence_Speculate(t *testing.T) {
 	t.Run("speculative new file treated as inexistent", run(func(p *testpack) {
 		p.sess.addTask(taskf(
-			`{"dest": "%s", "speculative": true}`,
+			`{"dest": "%s", "speculate": true}`,
 			p.fs.path(testFile1)))
 
 		res, err := p.sess.addTask(taskf(
@@ -1220,7 +1327,7 @@ func Test_Mkdir(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testDir1).exists())
 	}))
 
@@ -1233,7 +1340,7 @@ func Test_Mkdir(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testDirPerm1, p.fs.dir(testDir1).mode())
 	}))
 
@@ -1262,7 +1369,7 @@ func Test_Mkdir_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testDir1).exists())
 	}))
 
@@ -1282,7 +1389,7 @@ func Test_Mkdir_Speculate(t *testing.T) {
 		p.assert.Error(err)
 		p.assert.Equal(testResFalse, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testDir1).exists())
 	}))
 
@@ -1313,7 +1420,7 @@ func Test_Mkdir_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.dir(testFile1).exists())
 	}))
 
@@ -1342,7 +1449,7 @@ func Test_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testFile1).exists())
 	}))
 
@@ -1355,7 +1462,7 @@ func Test_Speculate(t *testing.T) {
 		p.assert.NoError(err)
 		p.assert.Equal(testResTrue, res)
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
 	}))
 
@@ -1368,7 +1475,7 @@ func Test_Speculate(t *testing.T) {
 		p.assert.Equal(testResTrue, res)
 		p.assert.Equal([]string{testDir1}, p.fs.dir(testRootDir).ls())
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.True(p.fs.file(testDir1File1).exists())
 	}))
 
@@ -1403,7 +1510,7 @@ func Test_Speculate(t *testing.T) {
 			p.fs.path(testFile1),
 			testFilePerm2))
 
-		p.sess.done()
+		p.sess.done(context.Background())
 		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
 
 		p.sess.finalize()
@@ -1424,3 +1531,1423 @@ func Test_Speculate(t *testing.T) {
 		p.assert.Equal([]string{}, p.fs.dir(testRootDir).ls())
 	}))
 }
+
+func Test_Speculate_MaxSpeculations(t *testing.T) {
+	t.Run("oldest one evicted", run(func(p *testpack) {
+		p.sess.cfg.maxSpeculations = 1
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.done(context.Background())
+		p.assert.Equal([]string{testFile2}, p.fs.dir(testRootDir).ls())
+	}))
+
+	t.Run("claimed one not counted", run(func(p *testpack) {
+		p.sess.cfg.maxSpeculations = 1
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+
+		p.sess.done(context.Background())
+		p.assert.Equal([]string{testFile1, testFile2}, p.fs.dir(testRootDir).ls())
+	}))
+
+	t.Run("same path speculated twice", run(func(p *testpack) {
+		p.sess.cfg.maxSpeculations = 1
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		p.sess.done(context.Background())
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testRootDir).ls())
+	}))
+}
+
+func Test_ReadHead(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_head": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testContent1[:4])+`"`, res)
+	}))
+
+	t.Run("shorter than requested", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_head": 512}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testContent1)+`"`, res)
+	}))
+
+	t.Run("capped", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_head": %d}`,
+			p.fs.path(testFile1),
+			len(testLongContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testLongContent1[:maxReadHeadBytes])+`"`, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_head": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_ReadHead_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_head": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("speculative existing file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_head": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testContent1[:4])+`"`, res)
+	}))
+}
+
+func Test_ReadRange(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_range": true, "offset": 2, "length": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testContent1[2:6])+`"`, res)
+	}))
+
+	t.Run("beyond the end", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_range": true, "offset": %d, "length": 512}`,
+			p.fs.path(testFile1),
+			len(testContent1)-2))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testContent1[len(testContent1)-2:])+`"`, res)
+	}))
+
+	t.Run("capped", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_range": true, "offset": 1, "length": %d}`,
+			p.fs.path(testFile1),
+			len(testLongContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`"`+b64String(testLongContent1[1:1+maxReadHeadBytes])+`"`, res)
+	}))
+
+	t.Run("negative offset", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_range": true, "offset": -1, "length": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("no length", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_range": true, "offset": 0}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+}
+
+func Test_ReadRange_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "read_range": true, "offset": 0, "length": 4}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_NormalizePath(t *testing.T) {
+	t.Run("relative path resolved against root", run(func(p *testpack) {
+		p.sess.cfg.root = p.fs.baseDir
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			testFile1,
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("relative source resolved against root", run(func(p *testpack) {
+		p.sess.cfg.root = p.fs.baseDir
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			testFile1,
+			testFile2))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("absolute path ignores root", run(func(p *testpack) {
+		p.sess.cfg.root = "/nonexistent"
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_InRoot(t *testing.T) {
+	inRoot := func(p *testpack, dest string) string {
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "in_root": true}`, dest))
+		p.assert.NoError(err)
+		return res
+	}
+
+	t.Run("root", run(func(p *testpack) {
+		p.sess.cfg.root = filepath.Clean(p.fs.baseDir)
+
+		p.assert.Equal(testResTrue, inRoot(p, testDir1File1))
+		p.assert.Equal(testResTrue, inRoot(p, p.fs.path(testFile1)))
+		p.assert.Equal(testResFalse, inRoot(p, "../"+testFile1))
+		p.assert.Equal(testResFalse, inRoot(p, "/etc/passwd"))
+		p.assert.Equal(testResFalse, inRoot(p, p.sess.cfg.root+"x/"+testFile1))
+	}))
+
+	t.Run("allow prefix", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{filepath.Clean(p.fs.path(testDir1))}
+
+		p.assert.Equal(testResTrue, inRoot(p, p.fs.path(testDir1File1)))
+		p.assert.Equal(testResFalse, inRoot(p, p.fs.path(testFile1)))
+	}))
+
+	t.Run("no root", run(func(p *testpack) {
+		p.assert.Equal(testResTrue, inRoot(p, "/etc/passwd"))
+	}))
+}
+
+// failWriteWithNoSpace makes writes stop halfway with ENOSPC until the returned
+// function is called.
+func failWriteWithNoSpace() func() {
+	orig := writeFile
+	writeFile = func(file *os.File, b []byte) (int, error) {
+		n, err := file.Write(b[:len(b)/2])
+		if err != nil {
+			return n, err
+		}
+		return n, syscall.ENOSPC
+	}
+
+	return func() {
+		writeFile = orig
+	}
+}
+
+func Test_CopyFile_NoSpace(t *testing.T) {
+	t.Run("created file removed", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		defer failWriteWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("existing file kept", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent2)
+		p.fs.file(testFile2).write(testContent1)
+
+		defer failWriteWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("speculative new file removed", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		defer failWriteWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+
+		p.sess.finalize()
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("speculative existing file kept", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent2)
+		p.fs.file(testFile2).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		defer failWriteWithNoSpace()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.ErrorIs(err, syscall.ENOSPC)
+		p.assert.Equal(testResFalse, res)
+
+		p.sess.finalize()
+		p.assert.True(p.fs.file(testFile1).exists())
+	}))
+}
+
+func Test_Touch(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "touch": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("", p.fs.file(testFile1).read())
+	}))
+
+	t.Run("chmod", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "touch": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+	}))
+
+	t.Run("existing file keeps content", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		old := time.Now().Add(-time.Hour)
+		os.Chtimes(p.fs.path(testFile1), old, old)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "touch": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+
+		st, _ := os.Stat(p.fs.path(testFile1))
+		p.assert.True(st.ModTime().After(old))
+	}))
+
+	t.Run("parent dir doesn't exist", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "touch": true}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Touch_Speculate(t *testing.T) {
+	t.Run("speculative new file claimed", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "touch": true}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.True(p.fs.file(testDir1File1).exists())
+	}))
+}
+
+func Test_ExistenceMany(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"existence_many": ["%s", "%s", "%s"]}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[false,true,true]", res)
+	}))
+
+	t.Run("empty", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"existence_many": []}`))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[]", res)
+	}))
+}
+
+func Test_ExistenceMany_Speculate(t *testing.T) {
+	t.Run("speculative entries", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"existence_many": ["%s", "%s", "%s"]}`,
+			p.fs.path(testFile1),
+			p.fs.path(testDir1File1),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("[true,false,false]", res)
+	}))
+}
+
+func Test_FirstExisting(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"first_existing": ["%s", "%s", "%s"]}`,
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(fmt.Sprintf("%q", p.fs.path(testFile2)), res)
+	}))
+
+	t.Run("directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"first_existing": ["%s", "%s"]}`,
+			p.fs.path(testFile1),
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(fmt.Sprintf("%q", p.fs.path(testDir1)), res)
+	}))
+
+	t.Run("none", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"first_existing": ["%s"]}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"first_existing": ["%s", "%s"]}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(fmt.Sprintf("%q", p.fs.path(testFile2)), res)
+	}))
+}
+
+func Test_NeedMoreParameters(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s"}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("verbose", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s"}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+
+		hint := &needMoreParametersHint{}
+		p.assert.NoError(json.Unmarshal([]byte(res), hint))
+		p.assert.Equal("need more parameters", hint.Error)
+		p.assert.Equal("EINVAL", hint.Code)
+		p.assert.Contains(hint.Recognized, "speculate")
+		p.assert.Contains(hint.Recognized, "content_b64")
+	}))
+}
+
+func Test_ErrorCode(t *testing.T) {
+	t.Run("not verbose", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			sha256Hex(testContent1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "verify_sha256": "%s"}`,
+			p.fs.path(testFile1),
+			sha256Hex(testContent1)))
+
+		p.assert.Error(err)
+
+		r := &errorResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal("ENOENT", r.Code)
+		p.assert.Equal(err.Error(), r.Error)
+	}))
+
+	t.Run("not a directory", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s/%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			testFile2,
+			b64String(testContent2)))
+
+		p.assert.Error(err)
+
+		r := &errorResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal("ENOTDIR", r.Code)
+	}))
+
+	t.Run("invalid task", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+
+		res, err := p.sess.addTask([]byte(`{"dest": 1}`))
+
+		p.assert.Error(err)
+
+		r := &errorResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal("EINVAL", r.Code)
+	}))
+
+	t.Run("success", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+}
+
+func Test_Speculate_Alias(t *testing.T) {
+	t.Run("speculative", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculative": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.done(context.Background())
+		p.assert.True(p.fs.file(testFile1).exists())
+	}))
+}
+
+func Test_UnknownFields(t *testing.T) {
+	t.Run("rejected", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete_recurse": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.ErrorContains(err, `"delete_recurse"`)
+		p.assert.Equal("null", res)
+		p.assert.True(p.fs.dir(testDir1).exists())
+	}))
+
+	t.Run("trailing data rejected", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "existence": true} {}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("ignored if lenient", run(func(p *testpack) {
+		p.sess.cfg.lenientJSON = true
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "existence": true, "unknown": 1}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+}
+
+func Test_CopyFile_Into(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "into": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("dest is file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "into": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("dest doesn't exist", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "into": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("dest is speculative directory", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "into": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Done(t *testing.T) {
+	t.Run("cancelled while speculation is stuck", run(func(p *testpack) {
+		tree := p.sess.speculativeDirTree
+		tree.childFiles[testFile1] = &speculativeFile{
+			name:   testFile1,
+			parent: tree,
+			done:   make(chan struct{}),
+		}
+		defer delete(tree.childFiles, testFile1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		p.assert.ErrorIs(p.sess.done(ctx), context.DeadlineExceeded)
+	}))
+}
+
+func Test_CreateFile_SkipUnchanged(t *testing.T) {
+	t.Run("unchanged", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		old := time.Now().Add(-time.Hour)
+		os.Chtimes(p.fs.path(testFile1), old, old)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "skip_unchanged": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("unchanged", res)
+
+		st, _ := os.Stat(p.fs.path(testFile1))
+		p.assert.True(st.ModTime().Equal(old))
+	}))
+
+	t.Run("changed", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "skip_unchanged": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("perm changed", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "skip_unchanged": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			b64String(testContent1),
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "skip_unchanged": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_CreateFile_SkipUnchanged_Speculate(t *testing.T) {
+	t.Run("speculative new file always written", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "", "skip_unchanged": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.True(p.fs.file(testFile1).exists())
+	}))
+}
+
+func Test_AllowPrefix(t *testing.T) {
+	t.Run("allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testDir1File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("dest not allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		before := metrics.blockedRequests.Load()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal("null", res)
+		p.assert.False(p.fs.file(testFile1).exists())
+		p.assert.Equal(before+1, metrics.blockedRequests.Load())
+	}))
+
+	t.Run("src not allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile1)))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal("null", res)
+		p.assert.False(p.fs.file(testDir1File1).exists())
+	}))
+
+	t.Run("sibling with common prefix not allowed", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "existence": true}`,
+			p.fs.path(testDir1+"2")))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal("null", res)
+	}))
+
+	t.Run("dot-dot resolved before check", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s/../%s", "existence": true}`,
+			p.fs.path(testDir1),
+			testFile1))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal("null", res)
+	}))
+}
+
+func Test_ListDir_Filter(t *testing.T) {
+	t.Run("dirs", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir2).create()
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_dirs": true}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testDir2, testDir1}, jsonSortedSlice(res))
+	}))
+
+	t.Run("files", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_files": true}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testFile1, testFile2}, jsonSortedSlice(res))
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_files": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("[]", res)
+	}))
+}
+
+func Test_ListDir_Filter_Speculate(t *testing.T) {
+	t.Run("speculative entries omitted", run(func(p *testpack) {
+		p.fs.dir(testDir2).create()
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_files": true}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testFile1}, jsonSortedSlice(res))
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_dirs": true}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testDir2}, jsonSortedSlice(res))
+	}))
+}
+
+func Test_Overwrite(t *testing.T) {
+	t.Run("create new", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": false}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("create existing", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": false}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("copy existing", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "overwrite": false}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("explicit true", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_Overwrite_Speculate(t *testing.T) {
+	t.Run("speculative existing file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": false}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": false}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_ListDir_Sorted(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir": true, "sorted": true}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(
+			fmt.Sprintf(`["%s","%s","%s"]`, testDir1, testFile1, testFile2),
+			res)
+	}))
+}
+
+func Test_ListDir_ModifiedSince(t *testing.T) {
+	since := time.Now().Add(-time.Minute)
+	old := since.Add(-time.Hour)
+
+	t.Run("typical", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent1)
+		p.assert.NoError(os.Chtimes(p.fs.path(testFile2), old, old))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir": true, "modified_since": %d}`,
+			p.fs.path(testRootDir),
+			since.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testDir1, testFile1}, jsonSortedSlice(res))
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir_files": true, "modified_since": %d}`,
+			p.fs.path(testRootDir),
+			since.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testFile1}, jsonSortedSlice(res))
+	}))
+
+	t.Run("not a directory", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir": true, "modified_since": %d}`,
+			p.fs.path(testFile1),
+			since.UnixNano()))
+
+		p.assert.Error(err)
+		p.assert.Equal("[]", res)
+	}))
+}
+
+func Test_ListDir_ModifiedSince_Speculate(t *testing.T) {
+	t.Run("speculative entries omitted", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "listdir": true, "modified_since": %d}`,
+			p.fs.path(testRootDir),
+			time.Now().Add(-time.Minute).UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal([]string{testFile1}, jsonSortedSlice(res))
+	}))
+}
+
+func Test_MaxWriteBytes(t *testing.T) {
+	t.Run("create", run(func(p *testpack) {
+		p.sess.cfg.maxWriteBytes = int64(len(testContent1)) - 1
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.ErrorIs(err, errTooLarge)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("create within limit", run(func(p *testpack) {
+		p.sess.cfg.maxWriteBytes = int64(len(testContent1))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("copy keeps existing destination", run(func(p *testpack) {
+		p.sess.cfg.maxWriteBytes = int64(len(testContent1)) - 1
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.file(testFile2).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.ErrorIs(err, errTooLarge)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent2, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("copy from unbounded source", run(func(p *testpack) {
+		if _, err := os.Stat("/dev/zero"); err != nil {
+			p.t.Skip("/dev/zero is unavailable")
+		}
+		p.sess.cfg.maxWriteBytes = 10
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "/dev/zero", "dest": "%s"}`,
+			p.fs.path(testFile1)))
+
+		p.assert.ErrorIs(err, errTooLarge)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+}
+
+func Test_CancelSpeculation(t *testing.T) {
+	t.Run("new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "cancel_speculation": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+		p.assert.Equal(0, p.sess.speculations.Len())
+	}))
+
+	t.Run("existing file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "cancel_speculation": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("not speculated", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "cancel_speculation": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_CreateExclusive(t *testing.T) {
+	t.Run("new", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "create_exclusive": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("existing", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "create_exclusive": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("speculative existing", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "create_exclusive": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
+
+func Test_Speculate_DirPerm(t *testing.T) {
+	t.Run("intermediate directories", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true, "dir_perm": %d}`,
+			p.fs.path(testDir1Dir2File1),
+			testDirPerm2))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testDir1Dir2File1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testDirPerm2, p.fs.dir(testDir1).mode())
+		p.assert.Equal(testDirPerm2, p.fs.dir(testDir1Dir2).mode())
+	}))
+
+	t.Run("existing directory untouched", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		before := p.fs.dir(testDir1).mode()
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true, "dir_perm": %d}`,
+			p.fs.path(testDir1Dir2File1),
+			testDirPerm2))
+
+		p.assert.Equal(before, p.fs.dir(testDir1).mode())
+		p.assert.Equal(testDirPerm2, p.fs.dir(testDir1Dir2).mode())
+	}))
+}
+
+func Test_NoSpeculation(t *testing.T) {
+	t.Run("speculate is a no-op", run(func(p *testpack) {
+		p.sess.cfg.noSpeculation = true
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.NoError(p.sess.done(context.Background()))
+		p.assert.False(p.fs.file(testFile1).exists())
+		p.assert.Empty(p.sess.speculativeDirTree.childFiles)
+	}))
+
+	t.Run("create after speculate", run(func(p *testpack) {
+		p.sess.cfg.noSpeculation = true
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("speculated directory isn't created", run(func(p *testpack) {
+		p.sess.cfg.noSpeculation = true
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.finalize()
+
+		p.assert.False(p.fs.dir(testDir1).exists())
+	}))
+}
+
+func Test_Speculate_Failure(t *testing.T) {
+	t.Run("parent is a file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s/%s", "speculate": true}`,
+			p.fs.path(testFile1),
+			testFile2))
+
+		p.assert.ErrorIs(err, syscall.ENOTDIR)
+		p.assert.Contains(err.Error(), p.fs.path(testFile1))
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("ancestor is a file", run(func(p *testpack) {
+		p.sess.cfg.verbose = true
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s/%s", "speculate": true}`,
+			p.fs.path(testFile1),
+			testDir1File1))
+
+		p.assert.ErrorIs(err, syscall.ENOTDIR)
+		p.assert.Contains(err.Error(), p.fs.path(testFile1))
+
+		r := &errorResult{}
+		p.assert.NoError(json.Unmarshal([]byte(res), r))
+		p.assert.Equal("ENOTDIR", r.Code)
+	}))
+
+	t.Run("failed open", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("failed open waited for", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true, "wait_open": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("eviction doesn't fail", run(func(p *testpack) {
+		p.sess.cfg.maxSpeculations = 1
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mktemp creates a uniquely named file in the directory and returns its path
+// as a JSON string. The file is kept open for a subsequent write to it.
+func (s *session) mktemp(dirPath, prefix string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("mktemp took %s", time.Since(start))
+	}()
+
+	if t := s.findSpeculativeDir(dirPath); t != nil && t.speculative {
+		return valFalse, fmt.Errorf("no such directory: %s", dirPath)
+	}
+
+	file, err := os.CreateTemp(dirPath, prefix)
+	if err != nil {
+		return valFalse, err
+	}
+
+	s.openFiles[file.Name()] = file
+
+	j, err := json.Marshal(file.Name())
+	if err != nil {
+		return valFalse, err
+	}
+
+	return string(j), nil
+}
+
+// useOpenFile takes over the file kept open by this session, if any.
+func (s *session) useOpenFile(path string) *os.File {
+	file, ok := s.openFiles[path]
+	if !ok {
+		return nil
+	}
+
+	delete(s.openFiles, path)
+	return file
+}
+
+// closeOpenFile closes the file kept open by this session, if any.
+func (s *session) closeOpenFile(path string) error {
+	if file := s.useOpenFile(path); file != nil {
+		return file.Close()
+	}
+
+	return nil
+}
+
+func (s *session) closeOpenFiles() {
+	for path, file := range s.openFiles {
+		if err := file.Close(); err != nil {
+			log.Errorf("failed to close: %s", path)
+		}
+	}
+
+	s.openFiles = map[string]*os.File{}
+}
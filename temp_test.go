@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Mktemp(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "mktemp": true, "prefix": "tmp-"}`,
+			p.fs.path(testRootDir)))
+
+		p.assert.NoError(err)
+
+		var path string
+		p.assert.NoError(json.Unmarshal([]byte(res), &path))
+		p.assert.Equal(p.fs.baseDir, filepath.Dir(path))
+		p.assert.True(strings.HasPrefix(filepath.Base(path), "tmp-"))
+		p.assert.True(newTestFile(path).exists())
+	}))
+
+	t.Run("write and move", run(func(p *testpack) {
+		res, _ := p.sess.addTask(taskf(
+			`{"dest": "%s", "mktemp": true}`,
+			p.fs.path(testRootDir)))
+
+		var path string
+		p.assert.NoError(json.Unmarshal([]byte(res), &path))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "perm": %d}`,
+			path,
+			b64String(testContent1),
+			testFilePerm1))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "move": true}`,
+			p.fs.path(testFile1),
+			path))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal([]string{testFile1}, p.fs.dir(testRootDir).ls())
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+	}))
+
+	t.Run("inexistent directory", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "mktemp": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setTimes changes the access and modification times of the file to the
+// given Unix nanoseconds. A nil time is left unchanged.
+func (s *session) setTimes(destPath string, atime, mtime *int64) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("setTimes took %s", time.Since(start))
+	}()
+
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return valFalse, fmt.Errorf("no such file or directory: %s", destPath)
+	}
+
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	a, m := fileAtime(fi), fi.ModTime()
+	if atime != nil {
+		a = time.Unix(0, *atime)
+	}
+	if mtime != nil {
+		m = time.Unix(0, *mtime)
+	}
+
+	if err := os.Chtimes(destPath, a, m); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
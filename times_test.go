@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_SetTimes(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	atime := time.Date(2021, 6, 7, 8, 9, 10, 11000, time.UTC)
+
+	modTime := func(p *testpack, name string) time.Time {
+		fi, err := os.Stat(p.fs.path(name))
+		p.assert.NoError(err)
+		return fi.ModTime()
+	}
+
+	t.Run("standalone", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "set_mtime": %d}`,
+			p.fs.path(testFile1),
+			mtime.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.True(mtime.Equal(modTime(p, testFile1)))
+	}))
+
+	t.Run("atime only", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.assert.NoError(os.Chtimes(p.fs.path(testFile1), mtime, mtime))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "set_atime": %d}`,
+			p.fs.path(testFile1),
+			atime.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.True(mtime.Equal(modTime(p, testFile1)))
+	}))
+
+	t.Run("with create", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "set_mtime": %d}`,
+			p.fs.path(testFile1),
+			b64String(testContent1),
+			mtime.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+		p.assert.True(mtime.Equal(modTime(p, testFile1)))
+	}))
+
+	t.Run("with copy", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "set_mtime": %d}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2),
+			mtime.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.True(mtime.Equal(modTime(p, testFile2)))
+	}))
+
+	t.Run("not written", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		before := modTime(p, testFile1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": false, "set_mtime": %d}`,
+			p.fs.path(testFile1),
+			b64String(testContent2),
+			mtime.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+		p.assert.True(before.Equal(modTime(p, testFile1)))
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "set_mtime": %d}`,
+			p.fs.path(testFile1),
+			mtime.UnixNano()))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_SetTimes_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "set_mtime": 0}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errTxUnsupported = errors.New("task not supported in a transaction")
+
+// txEntry records the state of a path before its first change in a
+// transaction.
+type txEntry struct {
+	path    string
+	created bool        // The path didn't exist.
+	backup  string      // Copy of the old content of a regular file.
+	mode    os.FileMode // Mode of the regular file.
+	link    *string     // Old target of a symbolic link.
+}
+
+// transaction journals the files changed by the tasks between begin_tx and
+// commit_tx, so that rollback_tx restores them. Only the content, the mode,
+// and the link target are restored; owners and times aren't, and directories
+// created on the way are left in place.
+type transaction struct {
+	entries   []*txEntry
+	journaled map[string]bool
+	aborted   error // The failure that already rolled back the transaction.
+}
+
+func (s *session) beginTx() (string, error) {
+	if s.tx != nil {
+		return valFalse, fmt.Errorf("transaction already in progress")
+	}
+
+	s.tx = &transaction{journaled: map[string]bool{}}
+	return valTrue, nil
+}
+
+// commitTx keeps the changes and discards the journal. It fails if a task
+// has already failed and rolled back the transaction.
+func (s *session) commitTx() (string, error) {
+	tx := s.tx
+	if tx == nil {
+		return valFalse, fmt.Errorf("no transaction in progress")
+	}
+	s.tx = nil
+
+	if tx.aborted != nil {
+		return valFalse, fmt.Errorf("transaction was rolled back: %w", tx.aborted)
+	}
+
+	for _, e := range tx.entries {
+		if e.backup == "" {
+			continue
+		}
+
+		if err := os.Remove(e.backup); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return valTrue, nil
+}
+
+func (s *session) rollbackTx() (string, error) {
+	tx := s.tx
+	if tx == nil {
+		return valFalse, fmt.Errorf("no transaction in progress")
+	}
+	s.tx = nil
+
+	if tx.aborted != nil {
+		return valTrue, nil
+	}
+
+	if err := s.restore(tx); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
+
+// execTaskInTx journals the paths the task changes before executing it,
+// and rolls back the whole transaction if it fails. Later tasks are refused
+// until the transaction ends.
+func (s *session) execTaskInTx(task *task) (string, error) {
+	if s.tx.aborted != nil {
+		return valFalse, fmt.Errorf("transaction was rolled back: %w", s.tx.aborted)
+	}
+
+	paths, err := s.txPaths(task)
+	if err == nil {
+		for _, path := range paths {
+			if err = s.journal(path); err != nil {
+				break
+			}
+		}
+	}
+
+	res := valFalse
+	if err == nil {
+		res, err = s.execTask(task)
+	}
+
+	if err != nil {
+		s.tx.aborted = err
+		if rerr := s.restore(s.tx); rerr != nil {
+			log.Error(rerr)
+		}
+	}
+
+	return res, err
+}
+
+// txPaths returns the paths the task may change. Tasks changing what can't be
+// journaled are refused, and so is any task not known to change only what's
+// journaled.
+func (s *session) txPaths(t *task) ([]string, error) {
+	switch {
+	case t.Sync, t.Untar != nil, t.Populate != nil, t.MoveMany != nil, t.MkdirMany != nil, t.Mkdir, t.Mktemp,
+		t.EmptyDir, t.DeleteRecursive, t.ChmodRecursive, t.ChownRecursive, t.CopyRecursive,
+		t.Open != nil, t.Handle != nil, t.CloseHandle != nil, t.Admin != nil, t.CancelSpeculation,
+		t.CleanTemp, t.PruneEmptyParents,
+		t.CloneMeta: // Owners aren't journaled.
+		return nil, errTxUnsupported
+	}
+
+	// The probe of "writable" is removed before it returns.
+	if t.readOnly() || t.Writable {
+		return nil, nil
+	}
+
+	changesDest := t.SourcePath != nil || t.Content != nil || t.Touch ||
+		t.Fallocate != nil || t.Delete || t.AppendRotate || t.Relink ||
+		t.ContentJSON != nil || t.Zero || t.EnsurePerm
+	if !changesDest {
+		return nil, errTxUnsupported
+	}
+
+	// The task normalizes the paths again, so resolve them without counting
+	// and logging a blocked one here.
+	destPath, err := s.resolvePath(t.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	var srcPath string
+	if t.SourcePath != nil {
+		if srcPath, err = s.resolvePath(*t.SourcePath); err != nil {
+			return nil, err
+		}
+	}
+
+	// Nothing to journal; the task refuses the path by itself.
+	if !s.allowed(destPath) || (t.SourcePath != nil && !s.allowed(srcPath)) {
+		return nil, nil
+	}
+
+	if t.Into && t.SourcePath != nil {
+		if destPath, err = s.intoDir(srcPath, destPath); err != nil {
+			return nil, err
+		}
+	}
+	paths := []string{destPath}
+
+	if t.AppendRotate {
+		paths = append(paths, destPath+".1")
+	}
+
+	if t.Backup {
+		paths = append(paths, destPath+backupSuffix)
+	}
+
+	if t.Move && t.SourcePath != nil {
+		paths = append(paths, srcPath)
+	}
+
+	return paths, nil
+}
+
+// journal records the state of the path unless already recorded.
+func (s *session) journal(path string) error {
+	start := time.Now()
+	defer func() {
+		log.Debugf("journal took %s", time.Since(start))
+	}()
+
+	if s.tx.journaled[path] {
+		return nil
+	}
+
+	e, err := s.snapshot(path)
+	if err != nil {
+		return err
+	}
+
+	s.tx.journaled[path] = true
+	s.tx.entries = append(s.tx.entries, e)
+	return nil
+}
+
+func (s *session) snapshot(path string) (*txEntry, error) {
+	if exists, found := s.speculativeExistence(path); found && !exists {
+		return &txEntry{path: path, created: true}, nil
+	}
+
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &txEntry{path: path, created: true}, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return &txEntry{path: path, link: &target}, nil
+	case fi.Mode().IsRegular():
+		backup, err := backupFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return &txEntry{path: path, backup: backup, mode: modeBits(fi.Mode())}, nil
+	}
+
+	return nil, fmt.Errorf("%w: not a regular file: %s", errTxUnsupported, path)
+}
+
+// backupFile copies the file to a hidden sibling and returns its path.
+func backupFile(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp, err := createTempSibling(path, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if err := os.Remove(tmp.Name()); err != nil {
+			log.Warn(err)
+		}
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// restore undoes the journaled changes in reverse order. It continues past
+// failures so that as much as possible is restored.
+func (s *session) restore(tx *transaction) error {
+	start := time.Now()
+	defer func() {
+		log.Debugf("restore took %s", time.Since(start))
+	}()
+
+	var errs []error
+	for i := len(tx.entries) - 1; i >= 0; i-- {
+		if err := s.restoreEntry(tx.entries[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	tx.entries = nil
+
+	return errors.Join(errs...)
+}
+
+func (s *session) restoreEntry(e *txEntry) error {
+	if e.created {
+		if _, err := s.deleteSingle(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if e.link != nil {
+		_, err := s.relink(*e.link, e.path, true)
+		return err
+	}
+
+	if _, err := s.takeOverDest(e.path); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(e.backup, e.mode); err != nil {
+		return err
+	}
+
+	return os.Rename(e.backup, e.path)
+}
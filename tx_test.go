@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Transaction(t *testing.T) {
+	begin := func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"begin_tx": true}`))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}
+
+	names := func(p *testpack) []string {
+		entries, err := os.ReadDir(filepath.Clean(p.fs.path(".")))
+		p.assert.NoError(err)
+
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	t.Run("commit", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		begin(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile2),
+			b64String(testContent1)))
+
+		res, err := p.sess.addTask([]byte(`{"commit_tx": true}`))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+		p.assert.Equal(testContent1, p.fs.file(testFile2).read())
+		p.assert.ElementsMatch([]string{testFile1, testFile2}, names(p))
+	}))
+
+	t.Run("rollback", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1).chmod(testFilePerm1)
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		begin(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "perm": %d}`,
+			p.fs.path(testFile1),
+			b64String(testContent2),
+			testFilePerm2))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile2),
+			b64String(testContent1)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true}`,
+			p.fs.path(testDir1File1)))
+
+		res, err := p.sess.addTask([]byte(`{"rollback_tx": true}`))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testLongContent1, p.fs.file(testFile1).read())
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+		p.assert.False(p.fs.file(testFile2).exists())
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+		p.assert.ElementsMatch([]string{testFile1, testDir1}, names(p))
+	}))
+
+	t.Run("rollback move", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "move": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.addTask([]byte(`{"rollback_tx": true}`))
+
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+		p.assert.False(p.fs.file(testFile2).exists())
+	}))
+
+	t.Run("failure rolls back", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		begin(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent2)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s"}`,
+			p.fs.path(testDir1File1),
+			p.fs.path(testFile2)))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+
+		// Later tasks are refused until the transaction ends.
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile2),
+			b64String(testContent2)))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile2).exists())
+
+		res, err = p.sess.addTask([]byte(`{"commit_tx": true}`))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+
+		// The transaction has ended.
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile2),
+			b64String(testContent2)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("unsupported task", run(func(p *testpack) {
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "mkdir": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.ErrorIs(err, errTxUnsupported)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.dir(testDir1).exists())
+	}))
+
+	t.Run("rollback copy into", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1 + "/" + testFile1).write(testContent2)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "into": true}`,
+			p.fs.path(testDir1),
+			p.fs.path(testFile1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testDir1+"/"+testFile1).read())
+
+		res, err = p.sess.addTask([]byte(`{"rollback_tx": true}`))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testContent2, p.fs.file(testDir1+"/"+testFile1).read())
+	}))
+
+	t.Run("rollback ensure_perm", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1).chmod(testFilePerm1)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "ensure_perm": true, "perm": %d}`,
+			p.fs.path(testFile1),
+			testFilePerm2))
+		p.assert.NoError(err)
+		p.assert.Equal(valChanged, res)
+		p.assert.Equal(testFilePerm2, p.fs.file(testFile1).mode())
+
+		res, err = p.sess.addTask([]byte(`{"rollback_tx": true}`))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.assert.Equal(testFilePerm1, p.fs.file(testFile1).mode())
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("times set alone unsupported", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "set_mtime": 0}`,
+			p.fs.path(testFile1)))
+
+		p.assert.ErrorIs(err, errTxUnsupported)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("clone_meta unsupported", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1).chmod(testFilePerm1)
+		p.fs.file(testFile2).write(testContent1).chmod(testFilePerm2)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "clone_meta": true}`,
+			p.fs.path(testFile2),
+			p.fs.path(testFile1)))
+
+		p.assert.ErrorIs(err, errTxUnsupported)
+		p.assert.Equal(testResFalse, res)
+		p.assert.Equal(testFilePerm2, p.fs.file(testFile2).mode())
+	}))
+
+	t.Run("clean_temp unsupported", run(func(p *testpack) {
+		p.fs.file(".old.tmp").write(testContent1)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "clean_temp": true, "pattern": ".*.tmp", "older_than_ms": 0}`,
+			p.fs.path(".")))
+
+		p.assert.ErrorIs(err, errTxUnsupported)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(".old.tmp").exists())
+	}))
+
+	t.Run("prune_empty_parents unsupported", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "delete": true, "prune_empty_parents": true}`,
+			p.fs.path(testDir1File1)))
+
+		p.assert.ErrorIs(err, errTxUnsupported)
+		p.assert.Equal(testResFalse, res)
+		p.assert.True(p.fs.file(testDir1File1).exists())
+	}))
+
+	t.Run("blocked path counted once", run(func(p *testpack) {
+		p.sess.cfg.allowPrefixes = []string{p.fs.path(testDir1)}
+		begin(p)
+		before := metrics.blockedRequests.Load()
+
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.ErrorIs(err, os.ErrPermission)
+		p.assert.Equal(before+1, metrics.blockedRequests.Load())
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("read-only task", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		begin(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "existence": true}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("nested begin", run(func(p *testpack) {
+		begin(p)
+
+		res, err := p.sess.addTask([]byte(`{"begin_tx": true}`))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("no transaction", run(func(p *testpack) {
+		res, err := p.sess.addTask([]byte(`{"commit_tx": true}`))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+
+		res, err = p.sess.addTask([]byte(`{"rollback_tx": true}`))
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("finalize rolls back", run(func(p *testpack) {
+		begin(p)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		p.sess.finalize()
+
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+}
+
+func Test_Transaction_Speculate(t *testing.T) {
+	t.Run("speculative existing file", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		p.sess.addTask([]byte(`{"begin_tx": true}`))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testLongContent1)))
+		p.sess.addTask([]byte(`{"rollback_tx": true}`))
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		p.sess.addTask([]byte(`{"begin_tx": true}`))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		p.sess.addTask([]byte(`{"rollback_tx": true}`))
+
+		p.sess.finalize()
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// untarConcurrency caps the concurrent writes of an untar task.
+const untarConcurrency = 16
+
+type untarEntry struct {
+	path    string
+	perm    os.FileMode
+	isDir   bool
+	content []byte
+}
+
+// untarName validates the entry name and returns it cleaned.
+// An empty result means the entry is dest itself.
+func untarName(name string) (string, error) {
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("absolute path in archive: %s", name)
+	}
+
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path escaping the destination in archive: %s", name)
+	}
+
+	if clean == "." {
+		return "", nil
+	}
+
+	return clean, nil
+}
+
+func readUntarEntries(archive []byte, destPath string) ([]*untarEntry, error) {
+	var entries []*untarEntry
+
+	r := tar.NewReader(bytes.NewReader(archive))
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := untarName(h.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "" {
+			continue
+		}
+
+		e := &untarEntry{
+			path: destPath + "/" + name,
+			perm: os.FileMode(h.Mode).Perm(),
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			e.isDir = true
+		case tar.TypeReg:
+			if e.content, err = io.ReadAll(r); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported entry type in archive: %s", h.Name)
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+// untar extracts the tar archive under dest and returns the number of files
+// written. Files the speculative tree or the session knows are written in
+// order through it; others concurrently.
+func (s *session) untar(archive []byte, destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("untar took %s", time.Since(start))
+	}()
+
+	// Validate the whole archive before touching the filesystem.
+	entries, err := readUntarEntries(archive, destPath)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if !s.existence(destPath) {
+		return valFalse, fmt.Errorf("no such directory: %s", destPath)
+	}
+
+	// The last of duplicate entries wins, as it would if written in order.
+	files := map[string]*untarEntry{}
+	for _, e := range entries {
+		if !e.isDir {
+			files[e.path] = e
+			continue
+		}
+
+		if err := s.ensureDir(e.path, e.perm); err != nil {
+			return valFalse, err
+		}
+	}
+
+	// The speculative tree isn't goroutine-safe; only touch disk concurrently.
+	eg := &errgroup.Group{}
+	eg.SetLimit(untarConcurrency)
+	for _, f := range files {
+		f := f
+
+		// Parents missing from the archive, like speculation would create.
+		if err := s.makeParents(f.path, nil); err != nil {
+			eg.Wait()
+			return valFalse, err
+		}
+
+		opts := &writeOptions{perm: &f.perm, overwrite: true}
+		if _, open := s.openFiles[f.path]; open || s.findSpeculativeFile(f.path) != nil {
+			if _, err := s.createFile(f.content, f.path, opts); err != nil {
+				eg.Wait()
+				return valFalse, err
+			}
+			continue
+		}
+
+		eg.Go(func() error {
+			_, err := s.createFileOnDisk(f.content, f.path, opts)
+			return err
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return valFalse, err
+	}
+
+	return strconv.Itoa(len(files)), nil
+}
+
+// ensureDir creates the directory with the mode, or changes the mode if it
+// already exists. Missing parents are created with the default mode.
+func (s *session) ensureDir(dirPath string, perm os.FileMode) error {
+	if !s.existence(dirPath) {
+		if parent := filepath.Dir(dirPath); !s.existence(parent) {
+			if err := s.ensureDir(parent, 0755); err != nil {
+				return err
+			}
+		}
+
+		return s.mkdir(dirPath, &perm)
+	}
+
+	st, err := os.Stat(dirPath)
+	if err != nil {
+		return err
+	}
+
+	if !st.IsDir() {
+		return fmt.Errorf("not a directory: %s", dirPath)
+	}
+
+	if st.Mode().Perm() == perm {
+		return nil
+	}
+
+	return os.Chmod(dirPath, perm)
+}
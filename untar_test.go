@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+type tarEntry struct {
+	name    string
+	mode    int64
+	content string
+	isDir   bool
+}
+
+func tarB64(p *testpack, entries ...tarEntry) string {
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:     e.name,
+			Mode:     e.mode,
+			Typeflag: tar.TypeReg,
+			Size:     int64(len(e.content)),
+		}
+		if e.isDir {
+			h.Typeflag = tar.TypeDir
+			h.Size = 0
+		}
+
+		p.assert.NoError(w.WriteHeader(h))
+		if !e.isDir {
+			_, err := w.Write([]byte(e.content))
+			p.assert.NoError(err)
+		}
+	}
+	p.assert.NoError(w.Close())
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func Test_Untar(t *testing.T) {
+	t.Run("typical", run(func(p *testpack) {
+		archive := tarB64(p,
+			tarEntry{name: testDir1 + "/", mode: int64(testDirPerm1), isDir: true},
+			tarEntry{name: testDir1File1, mode: int64(testFilePerm1), content: testContent1},
+			tarEntry{name: testFile1, mode: 0644, content: testContent2})
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testRootDir),
+			archive))
+
+		p.assert.NoError(err)
+		p.assert.Equal("2", res)
+		p.assert.Equal(testDirPerm1, p.fs.dir(testDir1).mode())
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+		p.assert.Equal(testFilePerm1, p.fs.file(testDir1File1).mode())
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1File1).read())
+	}))
+
+	t.Run("implicit parent directories", run(func(p *testpack) {
+		archive := tarB64(p,
+			tarEntry{name: testDir1Dir2File1, mode: 0644, content: testContent1})
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testRootDir),
+			archive))
+
+		p.assert.NoError(err)
+		p.assert.Equal("1", res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testDir1Dir2File1).read())
+	}))
+
+	t.Run("many files", run(func(p *testpack) {
+		var entries []tarEntry
+		for i := 0; i < untarConcurrency*2; i++ {
+			entries = append(entries, tarEntry{name: fmt.Sprintf("%s/%d.txt", testDir1, i), mode: 0644, content: testContent1})
+		}
+		archive := tarB64(p, entries...)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testRootDir),
+			archive))
+
+		p.assert.NoError(err)
+		p.assert.Equal(strconv.Itoa(untarConcurrency*2), res)
+		for i := 0; i < untarConcurrency*2; i++ {
+			p.assert.Equal(testContent1, p.fs.file(fmt.Sprintf("%s/%d.txt", testDir1, i)).read())
+		}
+	}))
+
+	t.Run("duplicate entries", run(func(p *testpack) {
+		archive := tarB64(p,
+			tarEntry{name: testFile1, mode: 0644, content: testContent1},
+			tarEntry{name: testFile1, mode: 0644, content: testContent2})
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testRootDir),
+			archive))
+
+		p.assert.NoError(err)
+		p.assert.Equal("1", res)
+		p.assert.Equal(testContent2, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("speculated file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		archive := tarB64(p,
+			tarEntry{name: testFile1, mode: 0644, content: testContent1})
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testRootDir),
+			archive))
+
+		p.assert.NoError(err)
+		p.assert.Equal("1", res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("parent reference", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		archive := tarB64(p,
+			tarEntry{name: "../" + testFile1, mode: 0644, content: testContent1})
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testDir1),
+			archive))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+
+	t.Run("absolute path", run(func(p *testpack) {
+		archive := tarB64(p,
+			tarEntry{name: p.fs.path(testFile1), mode: 0644, content: testContent1})
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "untar_b64": "%s"}`,
+			p.fs.path(testRootDir),
+			archive))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+		p.assert.False(p.fs.file(testFile1).exists())
+	}))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// verifyWritten reads the destination back after a successful write and
+// replaces the result with false if it doesn't match the stream want opens.
+// Both are streamed, so the content is never buffered twice.
+func (s *session) verifyWritten(res string, err error, destPath string, want func() (io.ReadCloser, error)) (string, error) {
+	if err != nil || res != valTrue {
+		return res, err
+	}
+
+	start := time.Now()
+	defer func() {
+		log.Debugf("verifyWritten took %s", time.Since(start))
+	}()
+
+	expected, err := want()
+	if err != nil {
+		return valFalse, err
+	}
+	defer expected.Close()
+
+	written, err := os.Open(destPath)
+	if err != nil {
+		return valFalse, err
+	}
+	defer written.Close()
+
+	differ, err := s.readersDiffer(expected, written)
+	if err != nil {
+		return valFalse, err
+	}
+
+	if differ {
+		log.Errorf("readback differs from what was written: %s", destPath)
+		return valFalse, nil
+	}
+
+	return valTrue, nil
+}
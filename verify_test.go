@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// corruptWrite makes writeFile flip the first byte silently until the
+// returned function is called.
+func corruptWrite() func() {
+	orig := writeFile
+	writeFile = func(file *os.File, b []byte) (int, error) {
+		corrupted := append([]byte{}, b...)
+		if len(corrupted) > 0 {
+			corrupted[0] ^= 0xff
+		}
+		return file.Write(corrupted)
+	}
+
+	return func() {
+		writeFile = orig
+	}
+}
+
+func Test_VerifyAfterWrite(t *testing.T) {
+	t.Run("create", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "verify_after_write": true}`,
+			p.fs.path(testFile1),
+			b64String(testLongContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testLongContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("create corrupted", run(func(p *testpack) {
+		defer corruptWrite()()
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "verify_after_write": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("copy", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "verify_after_write": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testLongContent1, p.fs.file(testFile2).read())
+	}))
+
+	t.Run("copy corrupted", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		defer corruptWrite()()
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "verify_after_write": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("exists", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent2)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "overwrite": false, "verify_after_write": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("exists", res)
+	}))
+}
+
+func Test_VerifyAfterWrite_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "verify_after_write": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+}
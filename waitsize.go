@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// waitSizeInterval is how often wait_size checks the file.
+const waitSizeInterval = 100 * time.Millisecond
+
+// reachedSize reports whether the file at destPath exists and is at least size
+// bytes. A speculatively created file doesn't exist until it is written.
+func (s *session) reachedSize(destPath string, size int64) (bool, error) {
+	if exists, found := s.speculativeExistence(destPath); found && !exists {
+		return false, nil
+	}
+
+	fi, err := os.Stat(destPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return fi.Size() >= size, nil
+}
+
+// waitSize polls the file at destPath until it is at least size bytes.
+// It returns false when timeout elapses first.
+func (s *session) waitSize(destPath string, size int64, timeout time.Duration) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("waitSize took %s", time.Since(start))
+	}()
+
+	if size < 0 {
+		return valFalse, fmt.Errorf("negative size: %d", size)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(waitSizeInterval)
+	defer ticker.Stop()
+
+	for {
+		reached, err := s.reachedSize(destPath, size)
+		if err != nil {
+			return valFalse, err
+		}
+		if reached {
+			return valTrue, nil
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return valFalse, s.ctx.Err()
+		case <-deadline.C:
+			return valFalse, nil
+		case <-ticker.C:
+		}
+	}
+}
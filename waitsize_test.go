@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_WaitSize(t *testing.T) {
+	t.Run("already reached", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": %d, "timeout_ms": 1000}`,
+			p.fs.path(testFile1),
+			len(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("grows", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		go func() {
+			time.Sleep(2 * waitSizeInterval)
+			f, err := os.OpenFile(p.fs.path(testFile1), os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			f.WriteString(testContent2)
+		}()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": %d, "timeout_ms": 5000}`,
+			p.fs.path(testFile1),
+			len(testContent1+testContent2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("timeout", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": %d, "timeout_ms": 50}`,
+			p.fs.path(testFile1),
+			len(testContent1)+1))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": 0, "timeout_ms": 50}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("cancelled", run(func(p *testpack) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		p.sess.ctx = ctx
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": 1, "timeout_ms": 5000}`,
+			p.fs.path(testFile1)))
+
+		p.assert.ErrorIs(err, context.Canceled)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("no timeout", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": 1}`,
+			p.fs.path(testFile1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("null", res)
+	}))
+}
+
+func Test_WaitSize_Speculate(t *testing.T) {
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "wait_size": 0, "timeout_ms": 50}`,
+			p.fs.path(testFile1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// walkFunc is called for each entry that logically exists.
+type walkFunc func(path string, fi os.FileInfo) error
+
+// concurrentWalk visits path and its descendants concurrently in pre-order.
+// Entries the speculative tree regards as nonexistent are skipped.
+// tree is the speculative node corresponding to path, or nil if none.
+// Symbolic links are visited but never followed. If fn returns
+// filepath.SkipDir for a directory, its descendants aren't visited.
+func concurrentWalk(path string, tree *dirTree, fn walkFunc) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(path, fi); err != nil {
+		if err == filepath.SkipDir && fi.IsDir() {
+			return nil
+		}
+		return err
+	}
+
+	if !fi.IsDir() {
+		return nil
+	}
+
+	// Closed before descending so that open directories don't pile up.
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	eg := &errgroup.Group{}
+	for _, n := range names {
+		var child *dirTree
+		if tree != nil {
+			if d, ok := tree.childDirs[n]; ok {
+				if d.speculative {
+					continue
+				}
+				child = d
+			}
+
+			if sf, ok := tree.file(n); ok && sf.getFutureFile().isNew {
+				continue
+			}
+		}
+
+		path := path + "/" + n
+		eg.Go(func() error {
+			return concurrentWalk(path, child, fn)
+		})
+	}
+
+	return eg.Wait()
+}
+
+// walkRoot returns the speculative node of the root of a walk,
+// and false if the root doesn't logically exist.
+func (s *session) walkRoot(path string) (*dirTree, bool) {
+	if f := s.findSpeculativeFile(path); f != nil {
+		return nil, !f.isNew
+	}
+
+	d := s.findSpeculativeDir(path)
+	if d != nil && d.speculative {
+		return nil, false
+	}
+
+	return d, true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// walkList returns a JSON array of the paths relative to root of the entries
+// under it whose base name matches pattern, in lexical order. Directories
+// deeper than maxDepth, where 1 means the direct children, aren't descended.
+// A negative maxDepth means no limit, and an empty pattern matches everything.
+// Unless since is zero, only entries modified after since are listed, while
+// older directories are still descended.
+func (s *session) walkList(root string, maxDepth int, pattern string, since time.Time) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("walkList took %s", time.Since(start))
+	}()
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return "[]", fmt.Errorf("invalid pattern: %s: %w", pattern, err)
+	}
+
+	tree, exists := s.walkRoot(root)
+	if !exists {
+		return "[]", fmt.Errorf("no such directory: %s", root)
+	}
+
+	// Speculative nodes of the directories being walked, keyed by path.
+	trees := map[string]*dirTree{root: tree}
+	paths := []string{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == root {
+			if !d.IsDir() {
+				return fmt.Errorf("not a directory: %s", root)
+			}
+			return nil
+		}
+
+		if parent := trees[filepath.Dir(path)]; parent != nil {
+			if !parent.logicallyExists(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if child, ok := parent.childDirs[d.Name()]; ok {
+				trees[path] = child
+			}
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		matched := true
+		if pattern != "" {
+			matched, _ = filepath.Match(pattern, d.Name())
+		}
+
+		if matched && !since.IsZero() {
+			if matched, err = modifiedAfter(d, since); err != nil {
+				return err
+			}
+		}
+
+		if matched {
+			paths = append(paths, rel)
+		}
+
+		if d.IsDir() && 0 <= maxDepth && maxDepth <= strings.Count(rel, "/")+1 {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "[]", err
+	}
+
+	j, err := json.Marshal(paths)
+	if err != nil {
+		return "[]", err
+	}
+
+	return string(j), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Walk(t *testing.T) {
+	walk := func(p *testpack, params string) []string {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true%s}`,
+			p.fs.path(testDir1),
+			params))
+		p.assert.NoError(err)
+
+		var paths []string
+		p.assert.NoError(json.Unmarshal([]byte(res), &paths))
+		return paths
+	}
+
+	setup := func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+		p.fs.file(testDir1Dir2File2).write(testContent2)
+	}
+
+	t.Run("all", run(func(p *testpack) {
+		setup(p)
+
+		p.assert.Equal([]string{
+			"anotherdir",
+			"anotherdir/test.txt",
+			"anotherdir/test2.txt",
+			"test.txt",
+		}, walk(p, ""))
+	}))
+
+	t.Run("max depth", run(func(p *testpack) {
+		setup(p)
+
+		p.assert.Equal([]string{"anotherdir", "test.txt"}, walk(p, `, "max_depth": 1`))
+	}))
+
+	t.Run("pattern", run(func(p *testpack) {
+		setup(p)
+
+		p.assert.Equal([]string{
+			"anotherdir/test2.txt",
+		}, walk(p, `, "pattern": "*2.txt"`))
+	}))
+
+	t.Run("pattern and max depth", run(func(p *testpack) {
+		setup(p)
+
+		p.assert.Equal([]string{"test.txt"}, walk(p, `, "pattern": "*.txt", "max_depth": 1`))
+	}))
+
+	t.Run("empty", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		p.assert.Equal([]string{}, walk(p, ""))
+	}))
+
+	t.Run("invalid pattern", run(func(p *testpack) {
+		setup(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true, "pattern": "["}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("[]", res)
+	}))
+
+	t.Run("invalid max depth", run(func(p *testpack) {
+		setup(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true, "max_depth": 0}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("[]", res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal("[]", res)
+	}))
+}
+
+func Test_Walk_ModifiedSince(t *testing.T) {
+	t.Run("old directory descended", run(func(p *testpack) {
+		since := time.Now().Add(-time.Minute)
+		old := since.Add(-time.Hour)
+
+		p.fs.dir(testDir1).create()
+		p.fs.dir(testDir1Dir2).create()
+		p.fs.file(testDir1File1).write(testContent1)
+		p.fs.file(testDir1Dir2File1).write(testContent1)
+		p.assert.NoError(os.Chtimes(p.fs.path(testDir1File1), old, old))
+		p.assert.NoError(os.Chtimes(p.fs.path(testDir1Dir2), old, old))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true, "modified_since": %d}`,
+			p.fs.path(testDir1),
+			since.UnixNano()))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`["anotherdir/test.txt"]`, res)
+	}))
+}
+
+func Test_Walk_Speculate(t *testing.T) {
+	t.Run("speculative entries skipped", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+		p.fs.file(testDir1File1).write(testContent1)
+
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File2)))
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1Dir2File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "walk": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(`["test.txt"]`, res)
+	}))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// isNotWritable reports whether the error means the directory refuses writes,
+// rather than that it can't be probed.
+func isNotWritable(err error) bool {
+	return errors.Is(err, os.ErrPermission) ||
+		errors.Is(err, syscall.EROFS) ||
+		errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, syscall.EDQUOT)
+}
+
+// writable reports whether a file can be created and removed in the directory
+// by creating a hidden probe file and removing it right away.
+func (s *session) writable(dirPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("writable took %s", time.Since(start))
+	}()
+
+	if t := s.findSpeculativeDir(dirPath); t != nil && t.speculative {
+		return valFalse, fmt.Errorf("no such directory: %s", dirPath)
+	}
+
+	probe, err := os.CreateTemp(dirPath, ".parallelefs-probe-*")
+	if err != nil {
+		if isNotWritable(err) {
+			return valFalse, nil
+		}
+		return valFalse, err
+	}
+
+	// Remove the probe even if closing it fails.
+	cerr := probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		if isNotWritable(err) {
+			log.Errorf("failed to remove probe file: %s", probe.Name())
+			return valFalse, nil
+		}
+		return valFalse, err
+	}
+
+	if cerr != nil {
+		return valFalse, cerr
+	}
+
+	return valTrue, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func Test_Writable(t *testing.T) {
+	t.Run("writable", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "writable": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		entries, err := os.ReadDir(p.fs.path(testDir1))
+		p.assert.NoError(err)
+		p.assert.Empty(entries)
+	}))
+
+	t.Run("read-only", run(func(p *testpack) {
+		if os.Geteuid() == 0 {
+			p.t.Skip("permissions are ignored for root")
+		}
+
+		p.fs.dir(testDir1).create()
+		p.assert.NoError(os.Chmod(p.fs.path(testDir1), 0555))
+		defer os.Chmod(p.fs.path(testDir1), 0755)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "writable": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "writable": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Writable_Speculate(t *testing.T) {
+	t.Run("speculative directory", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testDir1File1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "writable": true}`,
+			p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
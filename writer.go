@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// connWriter returns a function that sends a line to the connection.
+// It is the counterpart of connReader.
+func connWriter(conn io.Writer) func([]byte) error {
+	return func(line []byte) error {
+		buf := make([]byte, 0, len(line)+1)
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+
+		_, err := conn.Write(buf)
+		return err
+	}
+}
+
+// Leading bytes of responses in binary mode. Tokens are a single byte, and
+// anything else follows frameTag with a big-endian uint32 length.
+const (
+	binFalse   = 0x00
+	binTrue    = 0x01
+	binInvalid = 0x02
+	frameTag   = 0x03
+)
+
+// connFrameWriter returns a function that sends a response in binary mode.
+// It is a drop-in replacement for the function returned by connWriter.
+func connFrameWriter(conn io.Writer) func([]byte) error {
+	return func(res []byte) error {
+		var buf []byte
+		switch string(res) {
+		case valTrue:
+			buf = []byte{binTrue}
+		case valFalse:
+			buf = []byte{binFalse}
+		case valInvalid:
+			buf = []byte{binInvalid}
+		default:
+			buf = make([]byte, 5, len(res)+5)
+			buf[0] = frameTag
+			binary.BigEndian.PutUint32(buf[1:], uint32(len(res)))
+			buf = append(buf, res...)
+		}
+
+		_, err := conn.Write(buf)
+		return err
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// sizeXattr holds the content length in decimal for readers avoiding stat.
+const sizeXattr = "user.content_length"
+
+func setSizeXattr(file *os.File, size int64) error {
+	value := []byte(strconv.FormatInt(size, 10))
+	return unix.Fsetxattr(int(file.Fd()), sizeXattr, value, 0)
+}
+
+// copyXattrs replicates the extended attributes of src to dest. Failures of
+// individual attributes, such as of a namespace dest doesn't allow, are
+// logged and skipped if bestEffort.
+func copyXattrs(src, dest *os.File, bestEffort bool) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getXattr(src, name)
+		if err == nil {
+			err = unix.Fsetxattr(int(dest.Fd()), name, value, 0)
+		}
+		if err != nil {
+			if !bestEffort {
+				return fmt.Errorf("failed to copy xattr %s: %w", name, err)
+			}
+			log.Warnf("skipped xattr %s of %s: %s", name, src.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of the file.
+// A filesystem without xattrs has none.
+func listXattrs(file *os.File) ([]string, error) {
+	buf, err := readXattrBuffer(func(b []byte) (int, error) {
+		return unix.Flistxattr(int(file.Fd()), b)
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range bytes.Split(buf, []byte{0}) {
+		if len(name) != 0 {
+			names = append(names, string(name))
+		}
+	}
+
+	return names, nil
+}
+
+func getXattr(file *os.File, name string) ([]byte, error) {
+	return readXattrBuffer(func(b []byte) (int, error) {
+		return unix.Fgetxattr(int(file.Fd()), name, b)
+	})
+}
+
+// readXattrBuffer asks for the size first and reads, retrying if the value
+// grew in between.
+func readXattrBuffer(read func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+func setSizeXattr(file *os.File, size int64) error {
+	return errUnsupported
+}
+
+func copyXattrs(src, dest *os.File, bestEffort bool) error {
+	return errUnsupported
+}
@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func xattrOf(p *testpack, name, attr string) string {
+	buf := make([]byte, 64)
+	n, err := unix.Getxattr(p.fs.path(name), attr, buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+func sizeXattrOf(p *testpack, name string) string {
+	return xattrOf(p, name, sizeXattr)
+}
+
+func Test_StoreSizeXattr(t *testing.T) {
+	t.Run("create", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "store_size_xattr": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(strconv.Itoa(len(testContent1)), sizeXattrOf(p, testFile1))
+	}))
+
+	t.Run("create shorter", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "store_size_xattr": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(strconv.Itoa(len(testContent1)), sizeXattrOf(p, testFile1))
+	}))
+
+	t.Run("atomic", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "atomic": true, "store_size_xattr": true}`,
+			p.fs.path(testFile1),
+			b64String(testLongContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(strconv.Itoa(len(testLongContent1)), sizeXattrOf(p, testFile1))
+	}))
+
+	t.Run("copy", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testLongContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"src": "%s", "dest": "%s", "store_size_xattr": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(strconv.Itoa(len(testLongContent1)), sizeXattrOf(p, testFile2))
+		p.assert.Equal("", sizeXattrOf(p, testFile1))
+	}))
+
+	t.Run("not asked", run(func(p *testpack) {
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("", sizeXattrOf(p, testFile1))
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(
+			`{"dest": "%s", "speculate": true}`,
+			p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s", "store_size_xattr": true}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(strconv.Itoa(len(testContent1)), sizeXattrOf(p, testFile1))
+	}))
+}
+
+func Test_CopyXattrs(t *testing.T) {
+	setup := func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+		p.assert.NoError(unix.Setxattr(p.fs.path(testFile2), "user.content_type", []byte("text/plain"), 0))
+		p.assert.NoError(unix.Setxattr(p.fs.path(testFile2), "user.cache_tag", []byte("a"), 0))
+	}
+
+	t.Run("copied", run(func(p *testpack) {
+		setup(p)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "copy_xattrs": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+		p.assert.Equal("text/plain", xattrOf(p, testFile1, "user.content_type"))
+		p.assert.Equal("a", xattrOf(p, testFile1, "user.cache_tag"))
+	}))
+
+	t.Run("not asked", run(func(p *testpack) {
+		setup(p)
+
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s"}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal("", xattrOf(p, testFile1, "user.content_type"))
+	}))
+
+	t.Run("none", run(func(p *testpack) {
+		p.fs.file(testFile2).write(testContent1)
+
+		res, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "copy_xattrs": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+	}))
+
+	t.Run("stored size wins", run(func(p *testpack) {
+		setup(p)
+		p.assert.NoError(unix.Setxattr(p.fs.path(testFile2), sizeXattr, []byte("1"), 0))
+
+		_, err := p.sess.addTask(taskf(
+			`{"dest": "%s", "src": "%s", "copy_xattrs": true, "store_size_xattr": true}`,
+			p.fs.path(testFile1),
+			p.fs.path(testFile2)))
+
+		p.assert.NoError(err)
+		p.assert.Equal(strconv.Itoa(len(testContent1)), sizeXattrOf(p, testFile1))
+	}))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// zero truncates the file to zero bytes in place, unlike delete and create,
+// so that processes holding it open, such as log readers, keep valid fds.
+// A speculative or temporary fd of the file is used and stays open for
+// later writes.
+func (s *session) zero(destPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		log.Debugf("zero took %s", time.Since(start))
+	}()
+
+	if sf := s.findSpeculation(destPath); sf != nil && sf.withdraw() {
+		f := sf.getFutureFile()
+		if f.isNew {
+			return valFalse, fmt.Errorf("%w: %s", os.ErrNotExist, destPath)
+		}
+
+		if f.err == nil {
+			return truncateToZero(f.file)
+		}
+	}
+
+	if file, ok := s.openFiles[destPath]; ok {
+		return truncateToZero(file)
+	}
+
+	file, err := os.OpenFile(destPath, os.O_WRONLY, 0)
+	if err != nil {
+		return valFalse, err
+	}
+	defer file.Close()
+
+	return truncateToZero(file)
+}
+
+func truncateToZero(file *os.File) (string, error) {
+	if err := file.Truncate(0); err != nil {
+		return valFalse, err
+	}
+
+	// Later writes through the fd start over from the beginning.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return valFalse, err
+	}
+
+	return valTrue, nil
+}
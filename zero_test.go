@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func Test_Zero(t *testing.T) {
+	zero := func(p *testpack) (string, error) {
+		return p.sess.addTask(taskf(`{"dest": "%s", "zero": true}`, p.fs.path(testFile1)))
+	}
+
+	t.Run("inode kept", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent1)
+		reader, err := os.Open(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		defer reader.Close()
+
+		res, err := zero(p)
+
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		held, err := reader.Stat()
+		p.assert.NoError(err)
+		st, err := os.Stat(p.fs.path(testFile1))
+		p.assert.NoError(err)
+		p.assert.True(os.SameFile(held, st))
+		p.assert.Equal(int64(0), held.Size())
+	}))
+
+	t.Run("inexistent", run(func(p *testpack) {
+		res, err := zero(p)
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+
+	t.Run("directory", run(func(p *testpack) {
+		p.fs.dir(testDir1).create()
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "zero": true}`, p.fs.path(testDir1)))
+
+		p.assert.Error(err)
+		p.assert.Equal(testResFalse, res)
+	}))
+}
+
+func Test_Zero_Speculate(t *testing.T) {
+	t.Run("speculative fd kept", run(func(p *testpack) {
+		p.fs.file(testFile1).write(testContent2)
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "zero": true}`, p.fs.path(testFile1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+		p.assert.Equal("", p.fs.file(testFile1).read())
+
+		res, err = p.sess.addTask(taskf(
+			`{"dest": "%s", "content_b64": "%s"}`,
+			p.fs.path(testFile1),
+			b64String(testContent1)))
+		p.assert.NoError(err)
+		p.assert.Equal(testResTrue, res)
+
+		p.sess.finalize()
+		p.assert.Equal(testContent1, p.fs.file(testFile1).read())
+	}))
+
+	t.Run("speculative new file", run(func(p *testpack) {
+		p.sess.addTask(taskf(`{"dest": "%s", "speculate": true}`, p.fs.path(testFile1)))
+		p.sess.done(context.Background())
+
+		res, err := p.sess.addTask(taskf(`{"dest": "%s", "zero": true}`, p.fs.path(testFile1)))
+
+		p.assert.ErrorIs(err, os.ErrNotExist)
+		p.assert.Equal(testResFalse, res)
+	}))
+}